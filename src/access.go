@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// AccessRequest is pending request of unknown user to access the bot
+type AccessRequest struct {
+	UserID   int       `json:"user_id"`
+	UserName string    `json:"username,omitempty"`
+	Name     string    `json:"name"`
+	ChatID   int64     `json:"chat_id"`
+	At       time.Time `json:"at"`
+}
+
+// ApprovedUser is user granted access by admin, persisted in state
+type ApprovedUser struct {
+	ID         int       `json:"id"`
+	UserName   string    `json:"username,omitempty"`
+	Name       string    `json:"name"`
+	Role       string    `json:"role"`
+	ApprovedBy string    `json:"approved_by"`
+	At         time.Time `json:"at"`
+}
+
+// userDescription returns name, username & id of user for admin messages
+func userDescription(name, userName string, id int) string {
+	s := "<b>" + html.EscapeString(name) + "</b>"
+	if len(userName) > 0 {
+		s += " @" + userName
+	}
+	return s + fmt.Sprintf(" (id %d)", id)
+}
+
+func newCallbackButton(bot *TelegramBot, text string, callbackType string, data map[string]string) tgbotapi.InlineKeyboardButton {
+	cacheID := ksuid.New().String()
+	bot.Cache.Set(cacheID, Callback{
+		Type: callbackType,
+		Data: data,
+	})
+	return tgbotapi.NewInlineKeyboardButtonData(text, cacheID)
+}
+
+// requestAccess forwards access request of unknown user to admin chat
+// with buttons to approve user with one of roles or deny
+func requestAccess(bot *TelegramBot, m *tgbotapi.Message) (string, error) {
+	if cfg().AdminChatID == 0 {
+		return "I can't talk to you, sorry.", nil
+	}
+
+	r := AccessRequest{
+		UserID:   m.From.ID,
+		UserName: m.From.UserName,
+		Name:     m.From.FirstName + " " + m.From.LastName,
+		ChatID:   m.Chat.ID,
+		At:       time.Now(),
+	}
+	r.Name = strings.TrimSpace(r.Name)
+
+	added, err := bot.State.AddAccessRequest(r)
+	if err != nil {
+		return "", fmt.Errorf("error saving access request: %s", err)
+	}
+	if !added {
+		return "Your access request is pending, please wait for admin approval.", nil
+	}
+
+	// approved users are sent subscribed alerts, so private chat is remembered
+	if m.Chat.IsPrivate() {
+		if err := bot.State.SetUserChat(m.From, m.Chat.ID); err != nil {
+			log.Printf("error saving user chat: %s", err)
+		}
+	}
+
+	userID := strconv.Itoa(r.UserID)
+	var row []tgbotapi.InlineKeyboardButton
+	for _, role := range []string{roleViewer, roleOperator, roleAdmin} {
+		row = append(row, newCallbackButton(bot, "Approve as "+role, "approve", map[string]string{"user_id": userID, "role": role}))
+	}
+	kb := tgbotapi.NewInlineKeyboardMarkup(row, tgbotapi.NewInlineKeyboardRow(
+		newCallbackButton(bot, "Deny", "deny", map[string]string{"user_id": userID}),
+	))
+
+	msg := tgbotapi.NewMessage(cfg().AdminChatID, "Access request from "+userDescription(r.Name, r.UserName, r.UserID))
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.ReplyMarkup = kb
+	if err := sendMessage(bot, msg); err != nil {
+		return "", fmt.Errorf("error sending message to admin chat: %s", err)
+	}
+	log.Printf("access request from %s (%d) sent to admin chat", m.From.String(), m.From.ID)
+
+	return "Access request is sent to admins, you will be notified when it's processed.", nil
+}
+
+// processAccessCallback approves or denies access request and notifies user
+func processAccessCallback(bot *TelegramBot, cq *tgbotapi.CallbackQuery, cb Callback) error {
+	userID, err := strconv.Atoi(cb.Data["user_id"])
+	if err != nil {
+		return fmt.Errorf("wrong user id '%s': %s", cb.Data["user_id"], err)
+	}
+
+	r, err := bot.State.RemoveAccessRequest(userID)
+	if err != nil {
+		return fmt.Errorf("error removing access request: %s", err)
+	}
+
+	var adminText, userText string
+	switch {
+	case r == nil:
+		adminText = fmt.Sprintf("Access request of user %d is already processed.", userID)
+	case cb.Type == "approve":
+		u := ApprovedUser{
+			ID:         r.UserID,
+			UserName:   r.UserName,
+			Name:       r.Name,
+			Role:       cb.Data["role"],
+			ApprovedBy: userMention(cq.From),
+			At:         time.Now(),
+		}
+		if err := bot.State.SetApprovedUser(u); err != nil {
+			return fmt.Errorf("error saving approved user: %s", err)
+		}
+		log.Printf("user %d approved as %s by %s", u.ID, u.Role, u.ApprovedBy)
+
+		adminText = fmt.Sprintf("%s is approved as <b>%s</b> by %s", userDescription(r.Name, r.UserName, r.UserID), u.Role, html.EscapeString(u.ApprovedBy))
+		userText = fmt.Sprintf("Your access request is approved, role: %s.\n%s", u.Role, helpMsg)
+	default:
+		log.Printf("access request of user %d denied by %s", r.UserID, userMention(cq.From))
+
+		adminText = fmt.Sprintf("Access request of %s is denied by %s", userDescription(r.Name, r.UserName, r.UserID), html.EscapeString(userMention(cq.From)))
+		userText = "Your access request is denied."
+	}
+
+	msg := tgbotapi.NewEditMessageText(cq.Message.Chat.ID, cq.Message.MessageID, adminText)
+	msg.ParseMode = tgbotapi.ModeHTML
+	if err := sendMessage(bot, msg); err != nil {
+		return fmt.Errorf("error sending message: %s", err)
+	}
+
+	if len(userText) > 0 {
+		if err := sendMessage(bot, tgbotapi.NewMessage(r.ChatID, userText)); err != nil {
+			return fmt.Errorf("error sending message: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// configUser is user with role from config file
+type configUser struct {
+	Entry string
+	Role  string
+}
+
+// configUsers returns users with roles from config file,
+// user listed with several roles is returned once with the highest one
+func configUsers() (users []configUser) {
+	c := cfg()
+	seen := make(map[string]bool)
+	for _, l := range []struct {
+		role    string
+		entries []string
+	}{
+		{roleAdmin, c.RBAC.Admins},
+		{roleOperator, c.RBAC.Operators},
+		{roleOperator, c.Users},
+		{roleViewer, c.RBAC.Viewers},
+	} {
+		for _, e := range l.entries {
+			key := strings.ToLower(normalizeUser(e))
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			users = append(users, configUser{Entry: strings.TrimSpace(e), Role: l.role})
+		}
+	}
+	return
+}
+
+// newUsersMessage returns list of users with access, approved ones with buttons to manage them
+// users from config file are listed read-only, as they are managed there
+func newUsersMessage(bot *TelegramBot) (text string, kb *tgbotapi.InlineKeyboardMarkup) {
+	users := bot.State.GetApprovedUsers()
+	fromConfig := configUsers()
+	if len(users)+len(fromConfig) == 0 {
+		return "No users.", nil
+	}
+
+	var b strings.Builder
+	if len(fromConfig) > 0 {
+		b.WriteString("Users from config file (read-only):\n")
+		for _, u := range fromConfig {
+			entry := u.Entry
+			if _, err := strconv.Atoi(entry); err == nil {
+				entry = "id " + entry
+			} else {
+				entry = "@" + normalizeUser(entry)
+			}
+			fmt.Fprintf(&b, "• %s - %s\n", html.EscapeString(entry), u.Role)
+		}
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var row []tgbotapi.InlineKeyboardButton
+	if len(users) > 0 {
+		if len(fromConfig) > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("Approved users:\n")
+	}
+	for i, u := range users {
+		fmt.Fprintf(&b, "%d. %s - %s\n", i+1, userDescription(u.Name, u.UserName, u.ID), u.Role)
+
+		row = append(row, newCallbackButton(bot, strconv.Itoa(i+1), "user", map[string]string{"user_id": strconv.Itoa(u.ID)}))
+		if len(row) == cfg().KeyboardRows {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(newCallbackButton(bot, "Close menu", "close", nil)))
+
+	markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return b.String(), &markup
+}
+
+// newUserMessage returns approved user with buttons to change role or revoke access
+func newUserMessage(bot *TelegramBot, u *ApprovedUser) (text string, kb *tgbotapi.InlineKeyboardMarkup) {
+	text = fmt.Sprintf("%s\nRole: <b>%s</b>\nApproved by %s at %s", userDescription(u.Name, u.UserName, u.ID), u.Role, html.EscapeString(u.ApprovedBy), u.At.Format(cfg().TimeFormat))
+
+	userID := strconv.Itoa(u.ID)
+	var row []tgbotapi.InlineKeyboardButton
+	for _, role := range []string{roleViewer, roleOperator, roleAdmin} {
+		if role == u.Role {
+			continue
+		}
+		row = append(row, newCallbackButton(bot, "Make "+role, "set_role", map[string]string{"user_id": userID, "role": role}))
+	}
+
+	markup := tgbotapi.NewInlineKeyboardMarkup(
+		row,
+		tgbotapi.NewInlineKeyboardRow(
+			newCallbackButton(bot, "Revoke", "revoke", map[string]string{"user_id": userID}),
+			newCallbackButton(bot, "Back", "users", nil),
+		),
+	)
+	return text, &markup
+}
+
+// processUsersCallback shows approved users, changes their roles and revokes access
+func processUsersCallback(bot *TelegramBot, cq *tgbotapi.CallbackQuery, cb Callback) error {
+	var u *ApprovedUser
+	if cb.Type != "users" {
+		userID, err := strconv.Atoi(cb.Data["user_id"])
+		if err != nil {
+			return fmt.Errorf("wrong user id '%s': %s", cb.Data["user_id"], err)
+		}
+		u = bot.State.GetApprovedUser(userID)
+	}
+
+	switch {
+	case u == nil:
+	case cb.Type == "set_role":
+		u.Role = cb.Data["role"]
+		if err := bot.State.SetApprovedUser(*u); err != nil {
+			return fmt.Errorf("error saving approved user: %s", err)
+		}
+		log.Printf("user %d role changed to %s by %s", u.ID, u.Role, userMention(cq.From))
+	case cb.Type == "revoke":
+		if err := bot.State.RemoveApprovedUser(u.ID); err != nil {
+			return fmt.Errorf("error removing approved user: %s", err)
+		}
+		log.Printf("user %d access revoked by %s", u.ID, userMention(cq.From))
+		u = nil
+	}
+
+	text, kb := newUsersMessage(bot)
+	if u != nil {
+		text, kb = newUserMessage(bot, u)
+	}
+
+	msg := tgbotapi.NewEditMessageText(cq.Message.Chat.ID, cq.Message.MessageID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.ReplyMarkup = kb
+	if err := sendMessage(bot, msg); err != nil {
+		return fmt.Errorf("error sending message: %s", err)
+	}
+
+	return nil
+}
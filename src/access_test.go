@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ReneKroon/ttlcache/v2"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+func TestConfigUsers(t *testing.T) {
+	setTestConfig(t, func(c *Config) {
+		c.Users = []string{"@bob_1", "alice_1"}
+		c.RBAC = RBACConfig{
+			Viewers:   []string{"@carol_1", "123"},
+			Operators: []string{"123"},
+			Admins:    []string{"@Alice_1"},
+		}
+	})
+
+	want := []configUser{
+		{"@Alice_1", roleAdmin},
+		{"123", roleOperator},
+		{"@bob_1", roleOperator},
+		{"@carol_1", roleViewer},
+	}
+	if got := configUsers(); !reflect.DeepEqual(got, want) {
+		t.Errorf("configUsers() = %v, want %v", got, want)
+	}
+}
+
+func TestNewUsersMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		users    []string
+		approved []ApprovedUser
+		want     []string
+		wantKB   bool
+	}{
+		{
+			name: "no users",
+			want: []string{"No users."},
+		},
+		{
+			name:  "config users only",
+			users: []string{"<bob>", "123"},
+			want: []string{
+				"Users from config file (read-only):",
+				"• @&lt;bob&gt; - operator",
+				"• id 123 - operator",
+			},
+			wantKB: true,
+		},
+		{
+			name:     "config and approved users",
+			users:    []string{"@bob_1"},
+			approved: []ApprovedUser{{ID: 1, UserName: "alice_1", Name: "<Alice>", Role: roleViewer}},
+			want: []string{
+				"Users from config file (read-only):",
+				"• @bob_1 - operator",
+				"",
+				"Approved users:",
+				"1. <b>&lt;Alice&gt;</b> @alice_1 (id 1) - viewer",
+			},
+			wantKB: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, func(c *Config) {
+				c.Users = tt.users
+			})
+
+			state, err := loadState("")
+			if err != nil {
+				t.Fatalf("error loading state: %s", err)
+			}
+			for i, u := range tt.approved {
+				u.At = time.Unix(int64(i), 0)
+				if err := state.SetApprovedUser(u); err != nil {
+					t.Fatalf("error saving approved user: %s", err)
+				}
+			}
+
+			cache := ttlcache.NewCache()
+			defer cache.Close()
+			bot := &TelegramBot{State: state, Cache: cache}
+
+			text, kb := newUsersMessage(bot)
+			if got := strings.Split(strings.TrimSpace(text), "\n"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got text %q, want %q", got, tt.want)
+			}
+			if (kb != nil) != tt.wantKB {
+				t.Errorf("got keyboard %v, want keyboard %t", kb, tt.wantKB)
+			}
+			if kb != nil && len(kb.InlineKeyboard) != 1+len(tt.approved) {
+				t.Errorf("got %d keyboard rows, want %d", len(kb.InlineKeyboard), 1+len(tt.approved))
+			}
+		})
+	}
+}
+
+func TestAccessRequestFlow(t *testing.T) {
+	setTestConfig(t, nil)
+	bot, tg := newTestBot(t)
+
+	user := &tgbotapi.User{ID: 42, UserName: "dave", FirstName: "Dave"}
+	m := &tgbotapi.Message{From: user, Chat: &tgbotapi.Chat{ID: 42, Type: "private"}}
+
+	if text, err := requestAccess(bot, m); err != nil || !strings.Contains(text, "can't talk to you") {
+		t.Fatalf("requestAccess() = %q, %v without admin chat, want refusal", text, err)
+	}
+
+	setTestConfig(t, func(c *Config) {
+		c.AdminChatID = -100
+		c.SendGroupRate = 6000
+	})
+	if text, err := requestAccess(bot, m); err != nil || !strings.Contains(text, "sent to admins") {
+		t.Fatalf("requestAccess() = %q, %v, want request sent", text, err)
+	}
+	if text, _ := requestAccess(bot, m); !strings.Contains(text, "pending") {
+		t.Errorf("requestAccess() = %q of pending request, want pending", text)
+	}
+	if chatID, ok := bot.State.GetUserChat(user.ID); !ok || chatID != 42 {
+		t.Errorf("got private chat %d, %v of user, want 42", chatID, ok)
+	}
+
+	sent := tg.sent()
+	if len(sent) != 1 || sent[0].Params.Get("chat_id") != "-100" {
+		t.Fatalf("got requests %v, want one access request to admin chat", sent)
+	}
+
+	var kb tgbotapi.InlineKeyboardMarkup
+	if err := json.Unmarshal([]byte(sent[0].Params.Get("reply_markup")), &kb); err != nil {
+		t.Fatalf("error unmarshalling keyboard: %s", err)
+	}
+	want := [][]string{{"Approve as viewer", "Approve as operator", "Approve as admin"}, {"Deny"}}
+	if got := kbLabels(kb); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got keyboard %q, want %q", got, want)
+	}
+
+	v, err := bot.Cache.Get(*kb.InlineKeyboard[0][1].CallbackData)
+	if err != nil {
+		t.Fatalf("approve button callback not found: %s", err)
+	}
+	admin := &tgbotapi.User{ID: 1, UserName: "alice"}
+	cq := &tgbotapi.CallbackQuery{
+		From:    admin,
+		Message: &tgbotapi.Message{MessageID: 1, Chat: &tgbotapi.Chat{ID: -100}},
+	}
+	if err := processAccessCallback(bot, cq, v.(Callback)); err != nil {
+		t.Fatalf("processAccessCallback() error = %s", err)
+	}
+	if role := bot.userRole(user); role != roleOperator {
+		t.Errorf("got role %q of approved user, want operator", role)
+	}
+
+	sent = tg.sent()
+	if len(sent) != 3 || sent[1].Method != "editMessageText" || !strings.Contains(sent[1].Params.Get("text"), "approved as <b>operator</b> by @alice") {
+		t.Fatalf("got requests %v, want admin message edited", sent)
+	}
+	if sent[2].Params.Get("chat_id") != "42" || !strings.Contains(sent[2].Params.Get("text"), "approved, role: operator") {
+		t.Errorf("got request %v, want user notified", sent[2])
+	}
+
+	// the other admin pressed deny of the same request
+	v, _ = bot.Cache.Get(*kb.InlineKeyboard[1][0].CallbackData)
+	if err := processAccessCallback(bot, cq, v.(Callback)); err != nil {
+		t.Fatalf("processAccessCallback() error = %s", err)
+	}
+	if sent = tg.sent(); len(sent) != 4 || !strings.Contains(sent[3].Params.Get("text"), "already processed") {
+		t.Errorf("got requests %v, want request already processed", sent)
+	}
+
+	// role is changed and access revoked from /users
+	if err := processUsersCallback(bot, cq, Callback{Type: "set_role", Data: map[string]string{"user_id": "42", "role": roleViewer}}); err != nil {
+		t.Fatalf("processUsersCallback() error = %s", err)
+	}
+	if role := bot.userRole(user); role != roleViewer {
+		t.Errorf("got role %q after change, want viewer", role)
+	}
+	if err := processUsersCallback(bot, cq, Callback{Type: "revoke", Data: map[string]string{"user_id": "42"}}); err != nil {
+		t.Fatalf("processUsersCallback() error = %s", err)
+	}
+	if role := bot.userRole(user); role != "" {
+		t.Errorf("got role %q after revoke, want none", role)
+	}
+}
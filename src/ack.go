@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"html/template"
+	"log"
+	"time"
+
+	alerttmpl "github.com/prometheus/alertmanager/template"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// Ack is acknowledgement of alert group by user
+// fingerprints of group alerts are kept to show acks in /alerts
+type Ack struct {
+	User         string    `json:"user"`
+	At           time.Time `json:"at"`
+	Fingerprints []string  `json:"fingerprints"`
+}
+
+// userMention returns @username if user has one, or user name otherwise
+func userMention(u *tgbotapi.User) string {
+	if len(u.UserName) > 0 {
+		return "@" + u.UserName
+	}
+	return u.String()
+}
+
+// ackBanner returns text shown on acked alert messages
+func ackBanner(a *Ack, parseMode string) string {
+	at := a.At
+	if loc, err := time.LoadLocation(cfg().TimeZone); err == nil {
+		at = at.In(loc)
+	}
+
+	if parseMode == tgbotapi.ModeHTML {
+		return fmt.Sprintf("✋ Acked by <b>%s</b> at %s", html.EscapeString(a.User), at.Format("15:04"))
+	}
+	return fmt.Sprintf("✋ Acked by %s at %s", a.User, at.Format("15:04"))
+}
+
+// firingFingerprints returns fingerprints of firing webhook alerts
+func firingFingerprints(data alerttmpl.Data) (fingerprints []string) {
+	for _, a := range data.Alerts.Firing() {
+		fingerprints = append(fingerprints, a.Fingerprint)
+	}
+	return
+}
+
+// AlertGroup is alert group of webhook message, buttons of the message refer to it by id
+// groups are kept in state, so buttons work after restart
+type AlertGroup struct {
+	Key          string            `json:"key"`
+	Env          string            `json:"env"`
+	Alertmanager string            `json:"alertmanager,omitempty"`
+	Tenant       string            `json:"tenant,omitempty"`
+	GroupLabels  map[string]string `json:"group_labels"`
+	Fingerprints []string          `json:"fingerprints"`
+	// message text without ack banner to edit the message later
+	Text      string    `json:"text"`
+	ParseMode string    `json:"parse_mode"`
+	Silenced  bool      `json:"silenced,omitempty"`
+	Updated   time.Time `json:"updated"`
+}
+
+// alertGroupCallbacks are callback types of alert group buttons,
+// their callback data is "<type>:<group id>" instead of cache id
+var alertGroupCallbacks = map[string]bool{
+	"ack":     true,
+	"unack":   true,
+	"silence": true,
+}
+
+// groupID returns short id of alert group key fitting into callback data
+func groupID(groupKey string) string {
+	sum := sha256.Sum256([]byte(groupKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// silenceable checks if silence could be created for alert group
+// we want silence alerts by matching instance and alertname
+// so alertmanager grouping must be configured
+//
+//	group_by: ['instance','alertname'])
+func (g *AlertGroup) silenceable() bool {
+	return len(g.Alertmanager) > 0 && len(g.GroupLabels["instance"]) > 0 && len(g.GroupLabels["alertname"]) > 0
+}
+
+// groupKeyboard returns buttons of alert group message:
+// Silence unless group is silenced or could not be silenced, Ack or Unack
+func groupKeyboard(g *AlertGroup, acked bool) tgbotapi.InlineKeyboardMarkup {
+	id := groupID(g.Key)
+
+	var buttons []tgbotapi.InlineKeyboardButton
+	if g.silenceable() && !g.Silenced {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData("Silence", "silence:"+id))
+	}
+	if acked {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData("Unack", "unack:"+id))
+	} else {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData("Ack", "ack:"+id))
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(buttons...))
+}
+
+// alertGroupCallback returns callback of alert group button,
+// ok is false if group is not found (e.g. resolved or expired)
+func alertGroupCallback(bot *TelegramBot, callbackType string, id string) (cb Callback, ok bool) {
+	g := bot.State.GetAlertGroup(id)
+	if g == nil {
+		return cb, false
+	}
+
+	cb = Callback{
+		Type: callbackType,
+		Data: map[string]string{
+			"env":          g.Env,
+			"alertmanager": g.Alertmanager,
+			"tenant":       g.Tenant,
+			"group_id":     id,
+			"group_key":    g.Key,
+		},
+	}
+	return cb, true
+}
+
+// ackedMessageText returns text of message with buttons (the last chunk of long message)
+// with ack banner if ack is set
+func ackedMessageText(text string, parseMode string, ack *Ack) string {
+	chunks := splitMessage(text, parseMode)
+	if ack == nil {
+		return chunks[len(chunks)-1]
+	}
+	if len(chunks) == 1 {
+		return ackBanner(ack, parseMode) + "\n" + text
+	}
+	return chunks[len(chunks)-1] + "\n\n" + ackBanner(ack, parseMode)
+}
+
+// processAckCallback acks or unacks alert group and updates the message
+func processAckCallback(bot *TelegramBot, cq *tgbotapi.CallbackQuery, cb Callback) error {
+	g := bot.State.GetAlertGroup(cb.Data["group_id"])
+	if g == nil {
+		return fmt.Errorf("alert group %s not found", cb.Data["group_key"])
+	}
+
+	var ack *Ack
+	if cb.Type == "ack" {
+		ack = &Ack{
+			User:         userMention(cq.From),
+			At:           time.Now(),
+			Fingerprints: g.Fingerprints,
+		}
+
+		if err := bot.State.SetAck(g.Key, *ack); err != nil {
+			return fmt.Errorf("error saving ack: %s", err)
+		}
+		log.Printf("alert group %s acked by %s", g.Key, ack.User)
+
+		if err := bot.State.RemoveEscalation(g.Key); err != nil {
+			return fmt.Errorf("error removing escalation: %s", err)
+		}
+	} else {
+		if err := bot.State.RemoveAck(g.Key); err != nil {
+			return fmt.Errorf("error removing ack: %s", err)
+		}
+		log.Printf("alert group %s unacked by %s", g.Key, userMention(cq.From))
+	}
+
+	kb := groupKeyboard(g, ack != nil)
+	msg := tgbotapi.NewEditMessageText(cq.Message.Chat.ID, cq.Message.MessageID, ackedMessageText(g.Text, g.ParseMode, ack))
+	msg.ParseMode = g.ParseMode
+	msg.ReplyMarkup = &kb
+	if err := sendMessage(bot, msg); err != nil {
+		return fmt.Errorf("error sending message: %s", err)
+	}
+
+	return nil
+}
+
+// ackFuncs returns template functions for acks of alerts
+//
+//	{{ with ack .Fingerprint }}Acked by {{ .User }}{{ end }}
+func ackFuncs(bot *TelegramBot) template.FuncMap {
+	return template.FuncMap{
+		"ack": func(fingerprint interface{}) *Ack {
+			switch fp := fingerprint.(type) {
+			case string:
+				return bot.State.GetAckByFingerprint(fp)
+			case *string:
+				if fp != nil {
+					return bot.State.GetAckByFingerprint(*fp)
+				}
+			}
+			return nil
+		},
+	}
+}
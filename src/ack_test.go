@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+func TestGroupKeyboard(t *testing.T) {
+	silenceable := &AlertGroup{
+		Key:          "{}:{alertname=\"NodeDown\", instance=\"node1\"}",
+		Alertmanager: "main",
+		GroupLabels:  map[string]string{"alertname": "NodeDown", "instance": "node1"},
+	}
+	id := groupID(silenceable.Key)
+
+	tests := []struct {
+		name  string
+		group *AlertGroup
+		acked bool
+		want  []string
+	}{
+		{
+			name:  "silenceable",
+			group: silenceable,
+			want:  []string{"silence:" + id, "ack:" + id},
+		},
+		{
+			name:  "acked",
+			group: silenceable,
+			acked: true,
+			want:  []string{"silence:" + id, "unack:" + id},
+		},
+		{
+			name:  "silenced",
+			group: &AlertGroup{Key: silenceable.Key, Alertmanager: "main", GroupLabels: silenceable.GroupLabels, Silenced: true},
+			want:  []string{"ack:" + id},
+		},
+		{
+			name:  "not grouped by instance",
+			group: &AlertGroup{Key: silenceable.Key, Alertmanager: "main", GroupLabels: map[string]string{"alertname": "NodeDown"}},
+			want:  []string{"ack:" + id},
+		},
+		{
+			name:  "alertmanager unknown",
+			group: &AlertGroup{Key: silenceable.Key, GroupLabels: silenceable.GroupLabels},
+			want:  []string{"ack:" + id},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, btn := range groupKeyboard(tt.group, tt.acked).InlineKeyboard[0] {
+				got = append(got, *btn.CallbackData)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got buttons %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// callback data must fit into 64 bytes
+	if l := len("silence:" + id); l > 64 {
+		t.Errorf("got callback data of %d bytes, want at most 64", l)
+	}
+}
+
+func TestAckedMessageText(t *testing.T) {
+	setTestConfig(t, func(c *Config) {
+		c.TimeZone = "UTC"
+	})
+	ack := &Ack{User: "@alice<", At: time.Date(2021, 1, 1, 12, 30, 0, 0, time.UTC)}
+
+	if got := ackBanner(ack, tgbotapi.ModeHTML); got != "✋ Acked by <b>@alice&lt;</b> at 12:30" {
+		t.Errorf("got html banner %q", got)
+	}
+	if got := ackBanner(ack, ""); got != "✋ Acked by @alice< at 12:30" {
+		t.Errorf("got plain banner %q", got)
+	}
+
+	if got := ackedMessageText("alert", "", nil); got != "alert" {
+		t.Errorf("got text %q without ack, want %q", got, "alert")
+	}
+	if got := ackedMessageText("alert", "", ack); got != "✋ Acked by @alice< at 12:30\nalert" {
+		t.Errorf("got text %q with ack", got)
+	}
+
+	// banner is added to the last chunk of long message, which has buttons
+	long := strings.Repeat("a", maxMessageTextLength) + "\nlast"
+	if got := ackedMessageText(long, "", ack); strings.TrimSpace(got) != "last\n\n✋ Acked by @alice< at 12:30" {
+		t.Errorf("got text %q of long message with ack", got)
+	}
+}
+
+func TestProcessAckCallback(t *testing.T) {
+	setTestConfig(t, nil)
+	bot, tg := newTestBot(t)
+
+	g := AlertGroup{
+		Key:          "{}:{alertname=\"NodeDown\"}",
+		Env:          "default",
+		GroupLabels:  map[string]string{"alertname": "NodeDown"},
+		Fingerprints: []string{"fp1"},
+		Text:         "NodeDown",
+	}
+	id := groupID(g.Key)
+	if err := bot.State.SetAlertGroup(id, g); err != nil {
+		t.Fatalf("error saving alert group: %s", err)
+	}
+	if err := bot.State.StartEscalation(g.Key, PendingEscalation{Policy: "default"}); err != nil {
+		t.Fatalf("error saving escalation: %s", err)
+	}
+
+	cq := &tgbotapi.CallbackQuery{
+		From:    &tgbotapi.User{ID: 42, UserName: "alice"},
+		Message: &tgbotapi.Message{MessageID: 10, Chat: &tgbotapi.Chat{ID: -1}},
+	}
+
+	cb, ok := alertGroupCallback(bot, "ack", id)
+	if !ok {
+		t.Fatalf("callback of alert group not found")
+	}
+	if err := processAckCallback(bot, cq, cb); err != nil {
+		t.Fatalf("processAckCallback() error = %s", err)
+	}
+
+	ack := bot.State.GetAck(g.Key)
+	if ack == nil || ack.User != "@alice" {
+		t.Fatalf("got ack %v, want ack by @alice", ack)
+	}
+	if a := bot.State.GetAckByFingerprint("fp1"); a == nil {
+		t.Errorf("ack not found by fingerprint")
+	}
+	if len(bot.State.GetEscalations()) != 0 {
+		t.Errorf("escalation of acked group is not cancelled")
+	}
+
+	sent := tg.sent()
+	if len(sent) != 1 || sent[0].Method != "editMessageText" {
+		t.Fatalf("got requests %v, want editMessageText", sent)
+	}
+	if text := sent[0].Params.Get("text"); !strings.HasPrefix(text, "✋ Acked by @alice") || !strings.HasSuffix(text, "\nNodeDown") {
+		t.Errorf("got text %q, want ack banner and group text", text)
+	}
+	if kb := sent[0].Params.Get("reply_markup"); !strings.Contains(kb, "unack:"+id) {
+		t.Errorf("got keyboard %s, want unack button", kb)
+	}
+
+	// ack is shown in templates by alert fingerprint
+	tmpl := template.Must(template.New("test").Funcs(tmplFuncMap).Funcs(ackFuncs(bot)).Parse(`{{ with ack . }}{{ .User }}{{ end }}`))
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, "fp1"); err != nil {
+		t.Fatalf("error executing template: %s", err)
+	}
+	if b.String() != "@alice" {
+		t.Errorf("got %q from ack template function, want %q", b.String(), "@alice")
+	}
+
+	cb, _ = alertGroupCallback(bot, "unack", id)
+	if err := processAckCallback(bot, cq, cb); err != nil {
+		t.Fatalf("processAckCallback() error = %s", err)
+	}
+	if ack := bot.State.GetAck(g.Key); ack != nil {
+		t.Errorf("got ack %v after unack, want nil", ack)
+	}
+	sent = tg.sent()
+	if len(sent) != 2 || sent[1].Params.Get("text") != "NodeDown" || !strings.Contains(sent[1].Params.Get("reply_markup"), "ack:"+id) {
+		t.Errorf("got requests %v, want message without ack banner and with ack button", sent)
+	}
+
+	if _, ok := alertGroupCallback(bot, "ack", "unknown"); ok {
+		t.Errorf("got callback of unknown alert group")
+	}
+}
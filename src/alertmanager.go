@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	httptransport "github.com/go-openapi/runtime/client"
+	"github.com/go-openapi/strfmt"
+	"github.com/prometheus/alertmanager/api/v2/client"
+	"github.com/prometheus/alertmanager/api/v2/client/alert"
+	"github.com/prometheus/alertmanager/api/v2/client/general"
+	"github.com/prometheus/alertmanager/api/v2/client/silence"
+	"github.com/prometheus/alertmanager/api/v2/models"
+)
+
+// AlertmanagerConfig is a named alertmanager from config file
+// urls are peers of one HA cluster
+type AlertmanagerConfig struct {
+	Name       string           `yaml:"name"`
+	URLs       []string         `yaml:"urls"`
+	HTTPConfig HTTPClientConfig `yaml:"http_config"`
+}
+
+// Alertmanager is a named alertmanager cluster
+// requests are failed over between cluster peers
+type Alertmanager struct {
+	apiHealth
+
+	Name  string
+	URLs  []string
+	peers []*client.Alertmanager
+
+	activeMtx sync.Mutex
+	active    int
+}
+
+func newAlertmanagerClient(u string, rt http.RoundTripper) (*client.Alertmanager, error) {
+	url, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+
+	alertmanagerPath := url.Path
+	if !strings.HasSuffix(alertmanagerPath, "/api/v2") {
+		alertmanagerPath = path.Join(alertmanagerPath, "/api/v2")
+	}
+
+	transport := httptransport.New(url.Host, alertmanagerPath, []string{url.Scheme})
+	transport.Transport = rt
+
+	return client.New(transport, strfmt.Default), nil
+}
+
+// newAlertmanagers creates alertmanager clients from configs
+// or a single one from defaultURL if configs are not set
+func newAlertmanagers(configs []AlertmanagerConfig, defaultURL string, defaultHTTPConfig HTTPClientConfig) (alertmanagers []*Alertmanager, err error) {
+	if len(configs) == 0 {
+		configs = []AlertmanagerConfig{
+			{
+				Name:       "default",
+				URLs:       []string{defaultURL},
+				HTTPConfig: defaultHTTPConfig,
+			},
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, c := range configs {
+		if len(c.Name) == 0 {
+			return nil, fmt.Errorf("alertmanager name is not set for '%s'", strings.Join(c.URLs, ","))
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("duplicate alertmanager name '%s'", c.Name)
+		}
+		names[c.Name] = true
+
+		if len(c.URLs) == 0 {
+			return nil, fmt.Errorf("no urls set for alertmanager '%s'", c.Name)
+		}
+
+		rt, err := newRoundTripper(c.HTTPConfig)
+		if err != nil {
+			return nil, fmt.Errorf("error creating alertmanager '%s' http client: %s", c.Name, err)
+		}
+
+		am := Alertmanager{
+			Name: c.Name,
+			URLs: c.URLs,
+		}
+		for _, u := range c.URLs {
+			alertCli, err := newAlertmanagerClient(u, rt)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing alertmanager '%s' url: %s", c.Name, err)
+			}
+			am.peers = append(am.peers, alertCli)
+		}
+
+		alertmanagers = append(alertmanagers, &am)
+	}
+
+	return
+}
+
+// do runs f against cluster peers starting from the last healthy one
+// until the first successful call, cluster is considered unreachable if all peers failed
+func (am *Alertmanager) do(f func(c *client.Alertmanager) error) (err error) {
+	am.activeMtx.Lock()
+	start := am.active
+	am.activeMtx.Unlock()
+
+	for i := range am.peers {
+		idx := (start + i) % len(am.peers)
+		if err = f(am.peers[idx]); err != nil {
+			if len(am.peers) > 1 {
+				log.Printf("alertmanager '%s' peer '%s' request error: %s", am.Name, am.URLs[idx], err)
+			}
+			continue
+		}
+
+		am.activeMtx.Lock()
+		am.active = idx
+		am.activeMtx.Unlock()
+
+		if am.update(nil) {
+			log.Printf("alertmanager '%s' is reachable again", am.Name)
+		}
+		return nil
+	}
+
+	if am.update(err) {
+		log.Printf("alertmanager '%s' is unreachable: %s", am.Name, err)
+	}
+
+	return
+}
+
+// URL returns url of the last healthy cluster peer
+func (am *Alertmanager) URL() string {
+	am.activeMtx.Lock()
+	defer am.activeMtx.Unlock()
+	return am.URLs[am.active]
+}
+
+func (am *Alertmanager) GetAlerts(ctx context.Context, filter []string) (alerts models.GettableAlerts, err error) {
+	err = am.do(func(c *client.Alertmanager) error {
+		al, err := c.Alert.GetAlerts(&alert.GetAlertsParams{
+			Filter:  filter,
+			Context: ctx,
+		})
+		if err != nil {
+			return err
+		}
+		alerts = al.GetPayload()
+		return nil
+	})
+	return
+}
+
+func (am *Alertmanager) GetSilences(ctx context.Context) (silences models.GettableSilences, err error) {
+	err = am.do(func(c *client.Alertmanager) error {
+		s, err := c.Silence.GetSilences(&silence.GetSilencesParams{
+			Context: ctx,
+		})
+		if err != nil {
+			return err
+		}
+		silences = s.GetPayload()
+		return nil
+	})
+	return
+}
+
+func (am *Alertmanager) PostSilence(ctx context.Context, s *models.PostableSilence) (silenceID string, err error) {
+	err = am.do(func(c *client.Alertmanager) error {
+		ok, err := c.Silence.PostSilences(&silence.PostSilencesParams{
+			Silence: s,
+			Context: ctx,
+		})
+		if err != nil {
+			return err
+		}
+		silenceID = ok.Payload.SilenceID
+		return nil
+	})
+	return
+}
+
+func (am *Alertmanager) GetStatus(ctx context.Context) (status *models.AlertmanagerStatus, err error) {
+	err = am.do(func(c *client.Alertmanager) error {
+		s, err := c.General.GetStatus(&general.GetStatusParams{
+			Context: ctx,
+		})
+		if err != nil {
+			return err
+		}
+		status = s.GetPayload()
+		return nil
+	})
+	return
+}
+
+// getAlertmanager returns alertmanager by name or nil if not found
+func (env *Environment) getAlertmanager(name string) *Alertmanager {
+	for _, am := range env.Alertmanagers {
+		if am.Name == name {
+			return am
+		}
+	}
+	return nil
+}
+
+// webhookAlertmanager returns alertmanager which sent the webhook
+// either by name or by matching webhook external url host with alertmanager urls
+// falls back to the only configured alertmanager, nil is returned if there are several of them
+func (env *Environment) webhookAlertmanager(name, externalURL string) *Alertmanager {
+	if len(name) > 0 {
+		return env.getAlertmanager(name)
+	}
+
+	if u, err := url.Parse(externalURL); err == nil && len(u.Host) > 0 {
+		for _, am := range env.Alertmanagers {
+			for _, amURL := range am.URLs {
+				if v, err := url.Parse(amURL); err == nil && v.Host == u.Host {
+					return am
+				}
+			}
+		}
+	}
+
+	if len(env.Alertmanagers) == 1 {
+		return env.Alertmanagers[0]
+	}
+	return nil
+}
+
+// getAlerts returns active alerts aggregated from all alertmanagers
+// unavailable alertmanagers are skipped unless all of them failed
+func (env *Environment) getAlerts(ctx context.Context, filter []string) (alerts models.GettableAlerts, err error) {
+	var failed int
+	for _, am := range env.Alertmanagers {
+		al, e := am.GetAlerts(ctx, filter)
+		if e != nil {
+			log.Printf("error getting alerts from alertmanager '%s': %s", am.Name, e)
+			err = e
+			failed++
+			continue
+		}
+		alerts = append(alerts, al...)
+	}
+
+	if failed < len(env.Alertmanagers) {
+		err = nil
+	}
+
+	return
+}
+
+// alertmanagerHeader returns header for output of given alertmanager
+// if more than one alertmanager is configured
+func alertmanagerHeader(env *Environment, am *Alertmanager) string {
+	if am == nil || len(env.Alertmanagers) < 2 {
+		return ""
+	}
+	return fmt.Sprintf("[%s]\n", am.Name)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+// newDownURL returns url of closed server
+func newDownURL() string {
+	srv := httptest.NewServer(nil)
+	srv.Close()
+	return srv.URL
+}
+
+func TestNewAlertmanagers(t *testing.T) {
+	tests := []struct {
+		name    string
+		configs []AlertmanagerConfig
+		wantErr bool
+	}{
+		{
+			name: "default alertmanager",
+		},
+		{
+			name: "cluster",
+			configs: []AlertmanagerConfig{
+				{Name: "main", URLs: []string{"http://am1:9093", "http://am2:9093"}},
+			},
+		},
+		{
+			name:    "name not set",
+			configs: []AlertmanagerConfig{{URLs: []string{"http://am1:9093"}}},
+			wantErr: true,
+		},
+		{
+			name: "duplicate name",
+			configs: []AlertmanagerConfig{
+				{Name: "main", URLs: []string{"http://am1:9093"}},
+				{Name: "main", URLs: []string{"http://am2:9093"}},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "no urls",
+			configs: []AlertmanagerConfig{{Name: "main"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid url",
+			configs: []AlertmanagerConfig{{Name: "main", URLs: []string{"http://am1:port"}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newAlertmanagers(tt.configs, "http://localhost:9093", HTTPClientConfig{})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newAlertmanagers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAlertmanagerFailover(t *testing.T) {
+	up := newTestAlertmanager(t, map[string]string{"alertname": "NodeDown"})
+	down := newDownURL()
+
+	alertmanagers, err := newAlertmanagers([]AlertmanagerConfig{
+		{Name: "main", URLs: []string{down, up.URL}},
+	}, "", HTTPClientConfig{})
+	if err != nil {
+		t.Fatalf("error creating alertmanagers: %s", err)
+	}
+	am := alertmanagers[0]
+
+	alerts, err := am.GetAlerts(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("GetAlerts() error = %s", err)
+	}
+	if len(alerts) != 1 {
+		t.Errorf("got %d alerts, want 1", len(alerts))
+	}
+	if am.URL() != up.URL {
+		t.Errorf("got active peer %s, want %s", am.URL(), up.URL)
+	}
+	if _, unreachable := am.unreachableSince(); unreachable {
+		t.Errorf("alertmanager is unreachable, want reachable")
+	}
+
+	up.Close()
+	if _, err := am.GetAlerts(context.Background(), nil); err == nil {
+		t.Errorf("GetAlerts() of unavailable cluster succeeded, want error")
+	}
+	if _, unreachable := am.unreachableSince(); !unreachable {
+		t.Errorf("alertmanager is reachable, want unreachable")
+	}
+}
+
+func TestEnvironmentGetAlerts(t *testing.T) {
+	am1 := newTestAlertmanager(t, map[string]string{"alertname": "NodeDown"})
+	am2 := newTestAlertmanager(t, map[string]string{"alertname": "DiskFull"}, map[string]string{"alertname": "Watchdog"})
+	down := newDownURL()
+
+	tests := []struct {
+		name      string
+		urls      []string
+		wantCount int
+		wantErr   bool
+	}{
+		{
+			name:      "aggregated",
+			urls:      []string{am1.URL, am2.URL},
+			wantCount: 3,
+		},
+		{
+			name:      "unavailable skipped",
+			urls:      []string{am1.URL, down},
+			wantCount: 1,
+		},
+		{
+			name:    "all unavailable",
+			urls:    []string{down, down},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var configs []AlertmanagerConfig
+			for i, u := range tt.urls {
+				configs = append(configs, AlertmanagerConfig{Name: string(rune('a' + i)), URLs: []string{u}})
+			}
+			alertmanagers, err := newAlertmanagers(configs, "", HTTPClientConfig{})
+			if err != nil {
+				t.Fatalf("error creating alertmanagers: %s", err)
+			}
+			env := &Environment{Alertmanagers: alertmanagers}
+
+			alerts, err := env.getAlerts(context.Background(), nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getAlerts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(alerts) != tt.wantCount {
+				t.Errorf("got %d alerts, want %d", len(alerts), tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestWebhookAlertmanager(t *testing.T) {
+	multi := &Environment{
+		Alertmanagers: []*Alertmanager{
+			{Name: "main", URLs: []string{"http://am1:9093", "http://am2:9093"}},
+			{Name: "staging", URLs: []string{"http://am-staging:9093"}},
+		},
+	}
+	single := &Environment{
+		Alertmanagers: []*Alertmanager{
+			{Name: "main", URLs: []string{"http://am1:9093"}},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		env         *Environment
+		amName      string
+		externalURL string
+		want        string
+	}{
+		{
+			name:   "by name",
+			env:    multi,
+			amName: "staging",
+			want:   "staging",
+		},
+		{
+			name:   "unknown name",
+			env:    multi,
+			amName: "dev",
+		},
+		{
+			name:        "by external url of peer",
+			env:         multi,
+			externalURL: "http://am2:9093/",
+			want:        "main",
+		},
+		{
+			name:        "unknown external url",
+			env:         multi,
+			externalURL: "http://am3:9093",
+		},
+		{
+			name:        "the only alertmanager",
+			env:         single,
+			externalURL: "https://alertmanager.example.com",
+			want:        "main",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			if am := tt.env.webhookAlertmanager(tt.amName, tt.externalURL); am != nil {
+				got = am.Name
+			}
+			if got != tt.want {
+				t.Errorf("got alertmanager '%s', want '%s'", got, tt.want)
+			}
+		})
+	}
+
+	if h := alertmanagerHeader(single, single.Alertmanagers[0]); h != "" {
+		t.Errorf("got header %q of the only alertmanager, want empty", h)
+	}
+	if h := alertmanagerHeader(multi, multi.Alertmanagers[1]); h != "[staging]\n" {
+		t.Errorf("got header %q, want %q", h, "[staging]\n")
+	}
+}
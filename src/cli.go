@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	alerttmpl "github.com/prometheus/alertmanager/template"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+	"gopkg.in/yaml.v3"
+)
+
+// checkConfig validates loaded config without connecting to any api,
+// config itself is already loaded and validated on startup
+func checkConfig() error {
+	c := cfg()
+
+	if len(c.TelegramToken) == 0 {
+		return fmt.Errorf("telegram token is not set")
+	}
+
+	// unknown keys are ignored on startup, but most likely are typos
+	if len(c.ConfigFile) > 0 {
+		f, err := os.Open(c.ConfigFile)
+		if err != nil {
+			return fmt.Errorf("error opening config file: %s", err)
+		}
+		defer f.Close()
+
+		decoder := yaml.NewDecoder(f)
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&Config{}); err != nil {
+			fmt.Printf("warning: %s\n", err)
+		}
+	}
+
+	if len(c.Users)+len(c.RBAC.Viewers)+len(c.RBAC.Operators)+len(c.RBAC.Admins) == 0 {
+		fmt.Printf("warning: no users allowed\n")
+	}
+
+	if features := statelessFeatures(c); len(features) > 0 {
+		fmt.Printf("warning: state_file_path is not set, %s are lost on restart\n", strings.Join(features, ", "))
+	}
+
+	fmt.Printf("config is valid\n")
+	return nil
+}
+
+// renderTemplate executes template with json input and prints
+// resulting messages as they would be sent to telegram
+// input is either alertmanager webhook payload, gettable alerts or digest data
+func renderTemplate(templatePath, inputPath string) error {
+	templates, err := loadTemplates([]string{templatePath}, cfg().TemplateDirs)
+	if err != nil {
+		return err
+	}
+	cfg().templates = templates
+
+	b, err := ioutil.ReadFile(inputPath)
+	if err != nil {
+		return fmt.Errorf("error reading input file: %s", err)
+	}
+
+	var in interface{}
+	var externalURL string
+	var fields map[string]json.RawMessage
+	b = bytes.TrimSpace(b)
+	switch {
+	case len(b) > 0 && b[0] == '[':
+		alerts := models.GettableAlerts{}
+		if err := json.Unmarshal(b, &alerts); err != nil {
+			return fmt.Errorf("error unmarshalling gettable alerts: %s", err)
+		}
+		in = alerts
+	case json.Unmarshal(b, &fields) == nil && fields["NewFiring"] != nil:
+		data := DigestData{}
+		if err := json.Unmarshal(b, &data); err != nil {
+			return fmt.Errorf("error unmarshalling digest: %s", err)
+		}
+		in = data
+	default:
+		data := alerttmpl.Data{}
+		if err := json.Unmarshal(b, &data); err != nil {
+			return fmt.Errorf("error unmarshalling webhook payload: %s", err)
+		}
+		in = data
+		externalURL = data.ExternalURL
+	}
+
+	s, err := applyTemplate(in, templatePath, externalURLFunc(externalURL), queryFuncs(withTenant(context.Background(), defaultTenant()), cfg().environments[0]))
+	if err != nil {
+		return err
+	}
+
+	chunks := splitMessage(s, tgbotapi.ModeHTML)
+	for i, c := range chunks {
+		fmt.Printf("----- message %d/%d (%d chars) -----\n%s\n", i+1, len(chunks), utf16Len(c), c)
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureStdout returns output printed by f
+func captureStdout(t *testing.T, f func() error) (string, error) {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("error creating pipe: %s", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- f()
+		w.Close()
+	}()
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("error reading output: %s", err)
+	}
+	return string(b), <-errCh
+}
+
+func TestCheckConfig(t *testing.T) {
+	path := writeTestConfig(t, "config.yaml", "telegram_token: token\nusers: [alice]\nunknown_option: 1\n")
+
+	tests := []struct {
+		name     string
+		f        func(c *Config)
+		want     []string
+		wantErr  bool
+		dontWant string
+	}{
+		{
+			name:    "token not set",
+			wantErr: true,
+		},
+		{
+			name: "valid",
+			f: func(c *Config) {
+				c.TelegramToken = "token"
+				c.Users = []string{"alice"}
+				c.StateFile = "state.json"
+			},
+			want:     []string{"config is valid"},
+			dontWant: "warning",
+		},
+		{
+			name: "warnings",
+			f: func(c *Config) {
+				c.TelegramToken = "token"
+				c.ConfigFile = path
+			},
+			want: []string{"warning: yaml: unmarshal errors", "unknown_option", "warning: no users allowed", "warning: state_file_path is not set", "config is valid"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, func(c *Config) {
+				c.TelegramToken = ""
+				if tt.f != nil {
+					tt.f(c)
+				}
+			})
+
+			out, err := captureStdout(t, checkConfig)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(out, s) {
+					t.Errorf("output %q doesn't contain %q", out, s)
+				}
+			}
+			if len(tt.dontWant) > 0 && strings.Contains(out, tt.dontWant) {
+				t.Errorf("output %q contains %q", out, tt.dontWant)
+			}
+		})
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	dir := t.TempDir()
+	tmpl := writeTestTemplate(t, dir, "test.tmpl", `{{ externalURL }}|{{ printf "%T" . }}`)
+
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "webhook payload",
+			input: `{"status": "firing", "externalURL": "http://am:9093", "alerts": [{"status": "firing", "labels": {"alertname": "NodeDown"}}]}`,
+			want:  "http://am:9093|template.Data",
+		},
+		{
+			name:  "gettable alerts",
+			input: `[{"labels": {"alertname": "NodeDown"}}, {"labels": {"alertname": "DiskFull"}}]`,
+			want:  "|models.GettableAlerts",
+		},
+		{
+			name:  "digest",
+			input: `{"Route": "default", "NewFiring": [{"status": "firing", "labels": {"alertname": "NodeDown"}}]}`,
+			want:  "|main.DigestData",
+		},
+		{
+			name:    "invalid json",
+			input:   `{"status": `,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, func(c *Config) {
+				c.environments = []*Environment{{Name: "default"}}
+			})
+			input := writeTestTemplate(t, dir, "input.json", tt.input)
+
+			out, err := captureStdout(t, func() error {
+				return renderTemplate(tmpl, input)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("renderTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !strings.Contains(out, "----- message 1/1") || !strings.Contains(out, "\n"+tt.want+"\n") {
+				t.Errorf("got output %q, want message %q", out, tt.want)
+			}
+		})
+	}
+
+	setTestConfig(t, nil)
+	if err := renderTemplate(tmpl, dir+"/missing.json"); err == nil {
+		t.Errorf("renderTemplate() of missing input succeeded, want error")
+	}
+}
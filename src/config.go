@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is bot configuration, populated from env vars, cli options and config file
+type Config struct {
+	ConfigFile                 string               `envconfig:"CONFIG_PATH"`
+	TelegramToken              string               `envconfig:"TELEGRAM_TOKEN" yaml:"telegram_token"`
+	AlermanagerURL             string               `envconfig:"ALERTMANAGER_URL" yaml:"alertmanager_url" default:"http://localhost:9093"`
+	AlertmanagerHTTPConfig     HTTPClientConfig     `yaml:"alertmanager_http_config" ignored:"true"`
+	Alertmanagers              []AlertmanagerConfig `yaml:"alertmanagers" ignored:"true"`
+	PrometheusURL              string               `envconfig:"PROMETHEUS_URL" yaml:"prometheus_url" default:"http://localhost:9090"`
+	PrometheusHTTPConfig       HTTPClientConfig     `yaml:"prometheus_http_config" ignored:"true"`
+	PrometheusServers          []PrometheusConfig   `yaml:"prometheus_servers" ignored:"true"`
+	APITimeout                 time.Duration        `envconfig:"API_TIMEOUT" yaml:"api_timeout" default:"10s"`
+	HealthCheckInterval        time.Duration        `envconfig:"HEALTH_CHECK_INTERVAL" yaml:"health_check_interval" default:"30s"`
+	KeyboardRows               int                  `envconfig:"KEYBOARD_ROWS" yaml:"keyboard_rows" default:"2"`
+	KeyboardPageSize           int                  `envconfig:"KEYBOARD_PAGE_SIZE" yaml:"keyboard_page_size" default:"20"`
+	KeyboardFailingFirst       bool                 `envconfig:"KEYBOARD_FAILING_FIRST" yaml:"keyboard_failing_first" default:"false"`
+	WebhookAlertsTemplatePath  string               `envconfig:"WEBHOOK_ALERTS_TEMPLATE_PATH" yaml:"webhook_alerts_template_path"`
+	GettableAlertsTemplatePath string               `envconfig:"GETTABLE_ALERTS_TEMPLATE_PATH" yaml:"gettable_alerts_template_path"`
+	SilencesTemplatePath       string               `envconfig:"SILENCES_TEMPLATE_PATH" yaml:"silences_template_path"`
+	TemplateQueryTimeout       time.Duration        `envconfig:"TEMPLATE_QUERY_TIMEOUT" yaml:"template_query_timeout" default:"5s"`
+	TemplateQueryCacheTTL      time.Duration        `envconfig:"TEMPLATE_QUERY_CACHE_TTL" yaml:"template_query_cache_ttl" default:"30s"`
+	TemplateDirs               []string             `envconfig:"TEMPLATE_DIRS" yaml:"template_dirs"`
+	Environments               []EnvironmentConfig  `yaml:"environments" ignored:"true"`
+	Tenants                    []TenantConfig       `yaml:"tenants" ignored:"true"`
+	Routes                     []RouteConfig        `yaml:"routes" ignored:"true"`
+	Escalations                []EscalationConfig   `yaml:"escalations" ignored:"true"`
+	Rotations                  []RotationConfig     `yaml:"oncall_rotations" ignored:"true"`
+	BindAddress                string               `envconfig:"BIND_ADDRESS" yaml:"bind_address" default:"0.0.0.0"`
+	BindPort                   int                  `envconfig:"BIND_PORT" yaml:"bind_port" default:"8088"`
+	DisableHTTP                bool                 `envconfig:"DISABLE_HTTP" yaml:"disable_http" default:"false"`
+	ReloadToken                string               `envconfig:"RELOAD_TOKEN" yaml:"reload_token"`
+	LogFile                    string               `envconfig:"LOGFILE_PATH" yaml:"logfile_path"`
+	StateFile                  string               `envconfig:"STATE_FILE_PATH" yaml:"state_file_path"`
+	AdminChatID                int64                `envconfig:"ADMIN_CHAT_ID" yaml:"admin_chat_id"`
+	Users                      []string             `envconfig:"USERS" yaml:"users"`
+	RBAC                       RBACConfig           `yaml:"rbac" ignored:"true"`
+	TimeFormat                 string               `envconfig:"TIMEFORMAT" yaml:"time_format" default:"02/01/2006 15:04:05"`
+	TimeZone                   string               `envconfig:"TIMEZONE" yaml:"time_zone" default:"Europe/Moscow"`
+	ButtonPrefixOK             string               `envconfig:"BUTTON_PREFIX_OK" yaml:"button_prefix_ok"`
+	ButtonPrefixFail           string               `envconfig:"BUTTON_PREFIX_FAIL" yaml:"button_prefix_fail"`
+	SendMessageRetryCount      int                  `envconfig:"SEND_MESSAGE_RETRY_COUNT" yaml:"send_message_retry_count" default:"3"`
+	SendGlobalRate             float64              `envconfig:"SEND_GLOBAL_RATE" yaml:"send_global_rate" default:"30"`
+	SendChatRate               float64              `envconfig:"SEND_CHAT_RATE" yaml:"send_chat_rate" default:"1"`
+	SendGroupRate              float64              `envconfig:"SEND_GROUP_RATE" yaml:"send_group_rate" default:"20"`
+	SeverityPriorities         map[string]int       `envconfig:"SEVERITY_PRIORITIES" yaml:"severity_priorities" default:"critical:2,warning:1"`
+	CoalescePriority           int                  `envconfig:"COALESCE_PRIORITY" yaml:"coalesce_priority" default:"2"`
+	MessageChunkNumbers        bool                 `envconfig:"MESSAGE_CHUNK_NUMBERS" yaml:"message_chunk_numbers" default:"false"`
+	DocumentThreshold          int                  `envconfig:"DOCUMENT_THRESHOLD" yaml:"document_threshold" default:"4096"`
+	SilenceDuration            time.Duration        `envconfig:"SILENCE_DURATION" yaml:"silence_duration" default:"1h"`
+	CallbackTTL                time.Duration        `envconfig:"CALLBACK_TTL" yaml:"callback_ttl" default:"24h"`
+
+	// runtime objects created from config
+	environments []*Environment
+	templates    map[string]*template.Template
+	routes       []*Route
+	escalations  []*EscalationPolicy
+	rotations    []*Rotation
+}
+
+var (
+	// current config, swapped on reload
+	config atomic.Value
+
+	// parsed cli options, applied on every config load
+	cli struct {
+		ConfigFile  string `docopt:"--config"`
+		CheckConfig bool   `docopt:"check-config"`
+		Render      bool   `docopt:"render"`
+		Template    string `docopt:"--template"`
+		Input       string `docopt:"--input"`
+	}
+)
+
+// cfg returns current config
+func cfg() *Config {
+	return config.Load().(*Config)
+}
+
+// loadConfig reads config from env vars, cli options and config file,
+// validates it and creates environments
+func loadConfig() (*Config, error) {
+	var c Config
+
+	// populate config from ENV first
+	err := envconfig.Process("", &c)
+	if err != nil {
+		return nil, fmt.Errorf("error processing env vars: %s", err)
+	}
+
+	// cli option takes precedence over env var
+	if len(cli.ConfigFile) > 0 {
+		c.ConfigFile = cli.ConfigFile
+	}
+
+	// read config from file
+	if len(c.ConfigFile) > 0 {
+		f, err := os.Open(c.ConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("error opening config file: %s", err)
+		}
+		defer f.Close()
+
+		decoder := yaml.NewDecoder(f)
+		err = decoder.Decode(&c)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing config file: %s", err)
+		}
+	}
+
+	if c.HealthCheckInterval <= 0 {
+		return nil, fmt.Errorf("health_check_interval must be positive")
+	}
+
+	if c.TemplateQueryTimeout <= 0 {
+		return nil, fmt.Errorf("template_query_timeout must be positive")
+	}
+
+	if c.TemplateQueryCacheTTL <= 0 {
+		return nil, fmt.Errorf("template_query_cache_ttl must be positive")
+	}
+
+	if c.CallbackTTL <= 0 {
+		return nil, fmt.Errorf("callback_ttl must be positive")
+	}
+
+	if err := validateUsers(c.Users); err != nil {
+		return nil, err
+	}
+
+	if err := validateRBAC(c.RBAC); err != nil {
+		return nil, fmt.Errorf("error validating rbac: %s", err)
+	}
+
+	if err := validateTenants(c.Tenants); err != nil {
+		return nil, fmt.Errorf("error validating tenants: %s", err)
+	}
+
+	// environments with alertmanager & prometheus clients
+	c.environments, err = newEnvironments(&c)
+	if err != nil {
+		return nil, fmt.Errorf("error creating environments: %s", err)
+	}
+
+	c.rotations, err = newRotations(c.Rotations, c.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("error creating on-call rotations: %s", err)
+	}
+
+	c.routes, err = newRoutes(c.Routes, c.TimeZone, c.rotations)
+	if err != nil {
+		return nil, fmt.Errorf("error creating routes: %s", err)
+	}
+
+	c.escalations, err = newEscalationPolicies(c.Escalations)
+	if err != nil {
+		return nil, fmt.Errorf("error creating escalation policies: %s", err)
+	}
+
+	// templates of all environments and routes are parsed once
+	var templatePaths []string
+	for _, env := range c.environments {
+		templatePaths = append(templatePaths, env.WebhookAlertsTemplatePath, env.GettableAlertsTemplatePath, env.SilencesTemplatePath)
+	}
+	for _, r := range c.routes {
+		if r.Digest != nil {
+			templatePaths = append(templatePaths, r.Digest.TemplatePath)
+		}
+		if r.QuietHours != nil {
+			templatePaths = append(templatePaths, r.QuietHours.TemplatePath)
+		}
+	}
+	c.templates, err = loadTemplates(templatePaths, c.TemplateDirs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func validateUsers(users []string) error {
+	for _, u := range users {
+		if len(strings.TrimSpace(u)) == 0 {
+			return fmt.Errorf("empty user name in users list")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestConfig writes config file to test temp dir and returns its path
+func writeTestConfig(t *testing.T, name, data string) string {
+	t.Helper()
+
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, []byte(data), 0600); err != nil {
+		t.Fatalf("error writing config file: %s", err)
+	}
+	return p
+}
+
+func TestLoadConfigPath(t *testing.T) {
+	envPath := writeTestConfig(t, "env.yaml", "telegram_token: env\n")
+	cliPath := writeTestConfig(t, "cli.yaml", "telegram_token: cli\n")
+
+	tests := []struct {
+		name string
+		env  string
+		cli  string
+		want string
+	}{
+		{name: "env only", env: envPath, want: "env"},
+		{name: "cli only", cli: cliPath, want: "cli"},
+		{name: "cli over env", env: envPath, cli: cliPath, want: "cli"},
+	}
+
+	oldCLI := cli.ConfigFile
+	defer func() { cli.ConfigFile = oldCLI }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CONFIG_PATH", tt.env)
+			cli.ConfigFile = tt.cli
+
+			c, err := loadConfig()
+			if err != nil {
+				t.Fatalf("loadConfig() error = %s", err)
+			}
+			if c.TelegramToken != tt.want {
+				t.Errorf("loadConfig() telegram_token = %q, want %q", c.TelegramToken, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "zero health check interval", data: "health_check_interval: 0s\n"},
+		{name: "negative health check interval", data: "health_check_interval: -1s\n"},
+		{name: "zero template query timeout", data: "template_query_timeout: 0s\n"},
+		{name: "zero template query cache ttl", data: "template_query_cache_ttl: 0s\n"},
+	}
+
+	oldCLI := cli.ConfigFile
+	defer func() { cli.ConfigFile = oldCLI }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli.ConfigFile = writeTestConfig(t, "config.yaml", tt.data)
+
+			if _, err := loadConfig(); err == nil {
+				t.Errorf("loadConfig() expected error")
+			}
+		})
+	}
+}
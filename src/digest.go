@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	alerttmpl "github.com/prometheus/alertmanager/template"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// DigestData is data of digest and quiet hours summary templates
+type DigestData struct {
+	Route       string
+	QuietHours  bool
+	Environment string
+	From        time.Time
+	To          time.Time
+	NewFiring   alerttmpl.Alerts
+	StillFiring alerttmpl.Alerts
+	Resolved    alerttmpl.Alerts
+	// number of firing alerts per severity
+	Severities map[string]int
+}
+
+type digestKey struct {
+	route  string
+	chatID int64
+	// webhooks held during quiet hours
+	quiet bool
+}
+
+// String returns key of digest buffer in state
+func (k digestKey) String() string {
+	return fmt.Sprintf("%s/%d/%t", k.route, k.chatID, k.quiet)
+}
+
+// DigestBuffer keeps alerts received during digest interval by fingerprint
+// buffers are kept in state, so held webhooks are sent after restart
+type DigestBuffer struct {
+	Route        string                     `json:"route"`
+	ChatID       int64                      `json:"chat_id"`
+	Quiet        bool                       `json:"quiet,omitempty"`
+	Env          string                     `json:"env"`
+	TemplatePath string                     `json:"template_path,omitempty"`
+	From         time.Time                  `json:"from"`
+	Until        time.Time                  `json:"until"`
+	Firing       map[string]alerttmpl.Alert `json:"firing"`
+	Resolved     map[string]alerttmpl.Alert `json:"resolved"`
+}
+
+// ReportedAlert is alert reported as firing by digest,
+// seen is the time of the last digest alert was updated in
+type ReportedAlert struct {
+	Alert alerttmpl.Alert `json:"alert"`
+	Seen  time.Time       `json:"seen"`
+}
+
+func (b *DigestBuffer) key() digestKey {
+	return digestKey{route: b.Route, chatID: b.ChatID, quiet: b.Quiet}
+}
+
+// copy returns copy of buffer to save in state, as buffer is updated by later webhooks
+func (b *DigestBuffer) copy() DigestBuffer {
+	c := *b
+	c.Firing = make(map[string]alerttmpl.Alert, len(b.Firing))
+	for k, a := range b.Firing {
+		c.Firing[k] = a
+	}
+	c.Resolved = make(map[string]alerttmpl.Alert, len(b.Resolved))
+	for k, a := range b.Resolved {
+		c.Resolved[k] = a
+	}
+	return c
+}
+
+// Digests buffers webhooks of digest routes and sends them as one message per interval
+// alerts reported by the last digest are kept in state, so they are told from new ones after restart
+type Digests struct {
+	mtx     sync.Mutex
+	buffers map[digestKey]*DigestBuffer
+}
+
+func newDigests() *Digests {
+	return &Digests{
+		buffers: make(map[digestKey]*DigestBuffer),
+	}
+}
+
+func alertKey(env *Environment, a alerttmpl.Alert) string {
+	if len(a.Fingerprint) > 0 {
+		return env.Name + "/" + a.Fingerprint
+	}
+	return env.Name + "/" + toLabelSet(a.Labels).String()
+}
+
+// add buffers webhook alerts, digest is sent with template
+// after wait since the first webhook buffered
+func (d *Digests) add(bot *TelegramBot, key digestKey, env *Environment, data alerttmpl.Data, wait time.Duration, templatePath string) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	buf, ok := d.buffers[key]
+	if !ok {
+		now := time.Now()
+		buf = &DigestBuffer{
+			Route:        key.route,
+			ChatID:       key.chatID,
+			Quiet:        key.quiet,
+			TemplatePath: templatePath,
+			From:         now,
+			Until:        now.Add(wait),
+			Firing:       make(map[string]alerttmpl.Alert),
+			Resolved:     make(map[string]alerttmpl.Alert),
+		}
+		d.buffers[key] = buf
+
+		time.AfterFunc(wait, func() {
+			d.flush(bot, key)
+		})
+	}
+	buf.Env = env.Name
+
+	for _, a := range data.Alerts {
+		k := alertKey(env, a)
+		if a.Status == "resolved" {
+			buf.Resolved[k] = a
+			delete(buf.Firing, k)
+		} else {
+			buf.Firing[k] = a
+			delete(buf.Resolved, k)
+		}
+	}
+
+	if err := bot.State.SetDigestBuffer(key.String(), buf.copy()); err != nil {
+		log.Printf("error saving digest buffer: %s", err)
+	}
+}
+
+// restore schedules digests of buffers saved in state before restart,
+// overdue ones are sent right away
+func (d *Digests) restore(bot *TelegramBot) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	for _, buf := range bot.State.GetDigestBuffers() {
+		buf := buf
+		key := buf.key()
+		d.buffers[key] = &buf
+
+		time.AfterFunc(time.Until(buf.Until), func() {
+			d.flush(bot, key)
+		})
+	}
+}
+
+func sortedAlerts(m map[string]alerttmpl.Alert) (alerts alerttmpl.Alerts) {
+	for _, a := range m {
+		alerts = append(alerts, a)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].StartsAt.Before(alerts[j].StartsAt)
+	})
+	return
+}
+
+// classify splits buffered firing alerts into new and still firing ones
+// by alerts reported by the previous digest, returns alerts reported by this digest
+func (b *DigestBuffer) classify(prev map[string]ReportedAlert, now time.Time) (newFiring, stillFiring map[string]alerttmpl.Alert, reported map[string]ReportedAlert) {
+	reported = make(map[string]ReportedAlert)
+	newFiring = make(map[string]alerttmpl.Alert)
+	stillFiring = make(map[string]alerttmpl.Alert)
+	for k, a := range b.Firing {
+		if _, ok := prev[k]; ok {
+			stillFiring[k] = a
+		} else {
+			newFiring[k] = a
+		}
+		reported[k] = ReportedAlert{Alert: a, Seen: now}
+	}
+
+	// alerts reported before without updates are still firing,
+	// unless they are not seen for digest interval before the digest started,
+	// as alerts without resolved webhook would be reported forever
+	interval := b.Until.Sub(b.From)
+	for k, r := range prev {
+		_, firing := b.Firing[k]
+		_, resolved := b.Resolved[k]
+		if !firing && !resolved && b.From.Sub(r.Seen) <= interval {
+			stillFiring[k] = r.Alert
+			reported[k] = r
+		}
+	}
+
+	return
+}
+
+// flush sends digest of buffered alerts
+func (d *Digests) flush(bot *TelegramBot, key digestKey) {
+	d.mtx.Lock()
+	buf := d.buffers[key]
+	delete(d.buffers, key)
+	if err := bot.State.RemoveDigestBuffer(key.String()); err != nil {
+		log.Printf("error removing digest buffer: %s", err)
+	}
+
+	now := time.Now()
+	newFiring, stillFiring, reported := buf.classify(bot.State.GetDigestReported(key.String()), now)
+	if err := bot.State.SetDigestReported(key.String(), reported); err != nil {
+		log.Printf("error saving digest reported alerts: %s", err)
+	}
+	d.mtx.Unlock()
+
+	// quiet hours summary includes alerts that are still firing
+	if len(newFiring) == 0 && len(buf.Resolved) == 0 && (!key.quiet || len(stillFiring) == 0) {
+		return
+	}
+
+	// environment could be removed by config reload
+	env := bot.getEnvironment(buf.Env)
+	if env == nil {
+		log.Printf("unknown environment '%s' of digest, digest is dropped", buf.Env)
+		return
+	}
+
+	data := DigestData{
+		Route:       key.route,
+		QuietHours:  key.quiet,
+		Environment: env.Name,
+		From:        buf.From,
+		To:          now,
+		NewFiring:   sortedAlerts(newFiring),
+		StillFiring: sortedAlerts(stillFiring),
+		Resolved:    sortedAlerts(buf.Resolved),
+		Severities:  make(map[string]int),
+	}
+	for _, r := range reported {
+		data.Severities[r.Alert.Labels["severity"]]++
+	}
+
+	// send plain json if no template defined in config
+	var msg tgbotapi.MessageConfig
+	if len(buf.TemplatePath) == 0 {
+		b, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			log.Printf("error marshalling digest: %s", err)
+			return
+		}
+		msg = tgbotapi.NewMessage(key.chatID, string(b))
+	} else {
+		ctx := withTenant(context.Background(), defaultTenant())
+		s, err := applyTemplate(data, buf.TemplatePath, queryFuncs(ctx, env), oncallFuncs(bot))
+		if err != nil {
+			log.Printf("error applying digest template: %s", err)
+			return
+		}
+		msg = tgbotapi.NewMessage(key.chatID, environmentHeader(bot, env)+s)
+		msg.ParseMode = tgbotapi.ModeHTML
+	}
+
+	if err := sendMessageWithPriority(bot, msg, alertsPriority(append(data.NewFiring, data.StillFiring...)), ""); err != nil {
+		log.Printf("error sending digest: %s", err)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	alerttmpl "github.com/prometheus/alertmanager/template"
+)
+
+func alertKeys(m map[string]alerttmpl.Alert) (keys []string) {
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return
+}
+
+func TestDigestBufferClassify(t *testing.T) {
+	from := parseTestTime(t, "2026-10-19T10:00:00Z")
+	until := from.Add(time.Hour)
+
+	tests := []struct {
+		name      string
+		firing    []string
+		resolved  []string
+		prev      map[string]time.Time
+		wantNew   []string
+		wantStill []string
+	}{
+		{
+			name:    "new alerts",
+			firing:  []string{"a", "b"},
+			wantNew: []string{"a", "b"},
+		},
+		{
+			name:      "reported before",
+			firing:    []string{"a", "b"},
+			prev:      map[string]time.Time{"a": from.Add(-time.Minute)},
+			wantNew:   []string{"b"},
+			wantStill: []string{"a"},
+		},
+		{
+			name:      "reported before without updates",
+			firing:    []string{"b"},
+			prev:      map[string]time.Time{"a": from.Add(-time.Hour)},
+			wantNew:   []string{"b"},
+			wantStill: []string{"a"},
+		},
+		{
+			name:     "resolved",
+			resolved: []string{"a"},
+			prev:     map[string]time.Time{"a": from.Add(-time.Minute)},
+		},
+		{
+			name:    "not seen for digest interval",
+			firing:  []string{"b"},
+			prev:    map[string]time.Time{"a": from.Add(-time.Hour - time.Minute)},
+			wantNew: []string{"b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := DigestBuffer{
+				From:     from,
+				Until:    until,
+				Firing:   make(map[string]alerttmpl.Alert),
+				Resolved: make(map[string]alerttmpl.Alert),
+			}
+			for _, k := range tt.firing {
+				buf.Firing[k] = alerttmpl.Alert{Status: "firing", Fingerprint: k}
+			}
+			for _, k := range tt.resolved {
+				buf.Resolved[k] = alerttmpl.Alert{Status: "resolved", Fingerprint: k}
+			}
+			prev := make(map[string]ReportedAlert)
+			for k, seen := range tt.prev {
+				prev[k] = ReportedAlert{Alert: alerttmpl.Alert{Status: "firing", Fingerprint: k}, Seen: seen}
+			}
+
+			newFiring, stillFiring, reported := buf.classify(prev, until)
+			if got := alertKeys(newFiring); !reflect.DeepEqual(got, tt.wantNew) {
+				t.Errorf("got new firing %v, want %v", got, tt.wantNew)
+			}
+			if got := alertKeys(stillFiring); !reflect.DeepEqual(got, tt.wantStill) {
+				t.Errorf("got still firing %v, want %v", got, tt.wantStill)
+			}
+			if len(reported) != len(newFiring)+len(stillFiring) {
+				t.Errorf("got %d reported alerts, want %d", len(reported), len(newFiring)+len(stillFiring))
+			}
+			for k := range newFiring {
+				if !reported[k].Seen.Equal(until) {
+					t.Errorf("reported alert %s seen at %s, want %s", k, reported[k].Seen, until)
+				}
+			}
+		})
+	}
+}
+
+func TestDigestStatePersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	state, err := loadState(path)
+	if err != nil {
+		t.Fatalf("error loading state: %s", err)
+	}
+	bot := &TelegramBot{State: state}
+	env := &Environment{Name: "prod"}
+	key := digestKey{route: "night", chatID: -1}
+
+	d := newDigests()
+	data := alerttmpl.Data{Alerts: alerttmpl.Alerts{
+		{Status: "firing", Fingerprint: "a"},
+		{Status: "resolved", Fingerprint: "b"},
+	}}
+	d.add(bot, key, env, data, time.Hour, "")
+
+	seen := time.Now().Round(time.Second)
+	reported := map[string]ReportedAlert{"prod/c": {Alert: alerttmpl.Alert{Status: "firing", Fingerprint: "c"}, Seen: seen}}
+	if err := state.SetDigestReported(key.String(), reported); err != nil {
+		t.Fatalf("error saving reported alerts: %s", err)
+	}
+
+	restored, err := loadState(path)
+	if err != nil {
+		t.Fatalf("error loading state: %s", err)
+	}
+
+	buffers := restored.GetDigestBuffers()
+	if len(buffers) != 1 {
+		t.Fatalf("got %d digest buffers, want 1", len(buffers))
+	}
+	if got := alertKeys(buffers[0].Firing); !reflect.DeepEqual(got, []string{"prod/a"}) {
+		t.Errorf("got firing %v, want [prod/a]", got)
+	}
+	if got := alertKeys(buffers[0].Resolved); !reflect.DeepEqual(got, []string{"prod/b"}) {
+		t.Errorf("got resolved %v, want [prod/b]", got)
+	}
+	if buffers[0].key() != key {
+		t.Errorf("got buffer key %v, want %v", buffers[0].key(), key)
+	}
+
+	got := restored.GetDigestReported(key.String())
+	if len(got) != 1 || !got["prod/c"].Seen.Equal(seen) {
+		t.Errorf("got reported alerts %v, want %v", got, reported)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// sendOutput sends command output as message, or as document
+// with a short summary if output is longer than document threshold
+func sendOutput(bot *TelegramBot, chatID int64, text string, parseMode string, fileName string, summary string) error {
+	if threshold := cfg().DocumentThreshold; threshold > 0 && utf16Len(text) > threshold {
+		return sendDocument(bot, chatID, []byte(text), fileName, summary)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = parseMode
+	return sendMessage(bot, msg)
+}
+
+func sendDocument(bot *TelegramBot, chatID int64, data []byte, fileName string, caption string) error {
+	doc := tgbotapi.NewDocumentUpload(chatID, tgbotapi.FileBytes{
+		Name:  fileName,
+		Bytes: data,
+	})
+	doc.Caption = caption
+	return sendMessage(bot, doc)
+}
+
+// alertsCSV returns alerts as csv with columns for state, timestamps,
+// every label and every annotation found in alerts
+func alertsCSV(alerts models.GettableAlerts) ([]byte, error) {
+	labelNames := make(map[string]bool)
+	annotationNames := make(map[string]bool)
+	for _, a := range alerts {
+		for k := range a.Labels {
+			labelNames[k] = true
+		}
+		for k := range a.Annotations {
+			annotationNames[k] = true
+		}
+	}
+
+	sortedKeys := func(m map[string]bool) (keys []string) {
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return
+	}
+	labels := sortedKeys(labelNames)
+	annotations := sortedKeys(annotationNames)
+
+	header := []string{"fingerprint", "state", "starts_at", "ends_at", "updated_at"}
+	for _, l := range labels {
+		header = append(header, "label_"+l)
+	}
+	for _, a := range annotations {
+		header = append(header, "annotation_"+a)
+	}
+
+	formatTime := func(t interface{}) string {
+		if tt := toTime(t); !tt.IsZero() {
+			return tt.Format(time.RFC3339)
+		}
+		return ""
+	}
+
+	b := bytes.Buffer{}
+	w := csv.NewWriter(&b)
+	if err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	for _, a := range alerts {
+		var fingerprint, state string
+		if a.Fingerprint != nil {
+			fingerprint = *a.Fingerprint
+		}
+		if a.Status != nil && a.Status.State != nil {
+			state = *a.Status.State
+		}
+
+		record := []string{fingerprint, state, formatTime(a.StartsAt), formatTime(a.EndsAt), formatTime(a.UpdatedAt)}
+		for _, l := range labels {
+			record = append(record, a.Labels[l])
+		}
+		for _, an := range annotations {
+			record = append(record, a.Annotations[an])
+		}
+
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, fmt.Errorf("error writing csv: %s", err)
+	}
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/prometheus/alertmanager/api/v2/models"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+func TestAlertsCSV(t *testing.T) {
+	str := func(s string) *string { return &s }
+	startsAt := strfmt.DateTime(time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC))
+
+	alerts := models.GettableAlerts{
+		{
+			Alert: models.Alert{
+				Labels: models.LabelSet{"alertname": "NodeDown", "instance": "node1"},
+			},
+			Annotations: models.LabelSet{"summary": "node1, is down"},
+			Fingerprint: str("fp1"),
+			StartsAt:    &startsAt,
+			Status:      &models.AlertStatus{State: str("active")},
+		},
+		{
+			Alert: models.Alert{
+				Labels: models.LabelSet{"alertname": "DiskFull", "severity": "warning"},
+			},
+			Annotations: models.LabelSet{"description": `disk "/" is full`},
+		},
+	}
+
+	b, err := alertsCSV(alerts)
+	if err != nil {
+		t.Fatalf("alertsCSV() error = %s", err)
+	}
+
+	want := strings.Join([]string{
+		"fingerprint,state,starts_at,ends_at,updated_at,label_alertname,label_instance,label_severity,annotation_description,annotation_summary",
+		`fp1,active,2021-01-01T12:00:00Z,,,NodeDown,node1,,,"node1, is down"`,
+		`,,,,,DiskFull,,warning,"disk ""/"" is full",`,
+		"",
+	}, "\n")
+	if got := string(b); got != want {
+		t.Errorf("got csv\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestAlertmanagersJSON(t *testing.T) {
+	single := &Environment{Alertmanagers: []*Alertmanager{{Name: "main"}}}
+	multi := &Environment{Alertmanagers: []*Alertmanager{{Name: "main"}, {Name: "staging"}}}
+
+	tests := []struct {
+		name    string
+		env     *Environment
+		outputs map[string]interface{}
+		want    string
+	}{
+		{
+			name: "items of the only alertmanager",
+			env:  single,
+			outputs: map[string]interface{}{
+				"main": map[string]interface{}{"alerts": []string{"a"}},
+			},
+			want: "[\n  \"a\"\n]",
+		},
+		{
+			name: "keyed by alertmanager",
+			env:  multi,
+			outputs: map[string]interface{}{
+				"main":    map[string]interface{}{"alerts": []string{"a"}},
+				"staging": map[string]interface{}{"error": "unavailable"},
+			},
+			want: "{\n  \"main\": {\n    \"alerts\": [\n      \"a\"\n    ]\n  },\n  \"staging\": {\n    \"error\": \"unavailable\"\n  }\n}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := alertmanagersJSON(tt.env, tt.outputs, "alerts")
+			if err != nil {
+				t.Fatalf("alertmanagersJSON() error = %s", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendOutput(t *testing.T) {
+	tests := []struct {
+		name       string
+		threshold  int
+		text       string
+		wantMethod string
+	}{
+		{
+			name:       "threshold disabled",
+			text:       strings.Repeat("a", 100),
+			wantMethod: "sendMessage",
+		},
+		{
+			name:       "below threshold",
+			threshold:  100,
+			text:       strings.Repeat("a", 100),
+			wantMethod: "sendMessage",
+		},
+		{
+			name:       "above threshold",
+			threshold:  100,
+			text:       strings.Repeat("a", 101),
+			wantMethod: "sendDocument",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, func(c *Config) {
+				c.DocumentThreshold = tt.threshold
+			})
+			bot, tg := newTestBot(t)
+
+			if err := sendOutput(bot, 1, tt.text, tgbotapi.ModeHTML, "alerts.txt", "Active alerts: 1"); err != nil {
+				t.Fatalf("sendOutput() error = %s", err)
+			}
+
+			sent := tg.sent()
+			if len(sent) != 1 || sent[0].Method != tt.wantMethod {
+				t.Fatalf("got requests %v, want one %s", sent, tt.wantMethod)
+			}
+			switch tt.wantMethod {
+			case "sendMessage":
+				if got := sent[0].Params.Get("text"); got != tt.text {
+					t.Errorf("got text %q, want %q", got, tt.text)
+				}
+			case "sendDocument":
+				if sent[0].File != tt.text || sent[0].Params.Get("caption") != "Active alerts: 1" {
+					t.Errorf("got document %q with caption %q, want %q with summary", sent[0].File, sent[0].Params.Get("caption"), tt.text)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// EnvironmentConfig is a named stack from config file
+// unset fields are inherited from top level config
+type EnvironmentConfig struct {
+	Name                       string               `yaml:"name"`
+	AlermanagerURL             string               `yaml:"alertmanager_url"`
+	AlertmanagerHTTPConfig     HTTPClientConfig     `yaml:"alertmanager_http_config"`
+	Alertmanagers              []AlertmanagerConfig `yaml:"alertmanagers"`
+	PrometheusURL              string               `yaml:"prometheus_url"`
+	PrometheusHTTPConfig       HTTPClientConfig     `yaml:"prometheus_http_config"`
+	PrometheusServers          []PrometheusConfig   `yaml:"prometheus_servers"`
+	WebhookAlertsTemplatePath  string               `yaml:"webhook_alerts_template_path"`
+	GettableAlertsTemplatePath string               `yaml:"gettable_alerts_template_path"`
+	SilencesTemplatePath       string               `yaml:"silences_template_path"`
+	Users                      []string             `yaml:"users"`
+}
+
+// Environment is a set of alertmanagers, prometheus servers,
+// templates and users which chat could switch to
+type Environment struct {
+	Name                       string
+	Alertmanagers              []*Alertmanager
+	Prometheus                 []*PrometheusServer
+	WebhookAlertsTemplatePath  string
+	GettableAlertsTemplatePath string
+	SilencesTemplatePath       string
+	Users                      []string
+}
+
+func newEnvironments(cfg *Config) (environments []*Environment, err error) {
+	configs := cfg.Environments
+	if len(configs) == 0 {
+		configs = []EnvironmentConfig{
+			{
+				Name: "default",
+			},
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, c := range configs {
+		if len(c.Name) == 0 {
+			return nil, fmt.Errorf("environment name is not set")
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("duplicate environment name '%s'", c.Name)
+		}
+		names[c.Name] = true
+
+		// inherit top level config
+		if len(c.AlermanagerURL) == 0 && len(c.Alertmanagers) == 0 {
+			c.AlermanagerURL = cfg.AlermanagerURL
+			c.AlertmanagerHTTPConfig = cfg.AlertmanagerHTTPConfig
+			c.Alertmanagers = cfg.Alertmanagers
+		}
+		if len(c.PrometheusURL) == 0 && len(c.PrometheusServers) == 0 {
+			c.PrometheusURL = cfg.PrometheusURL
+			c.PrometheusHTTPConfig = cfg.PrometheusHTTPConfig
+			c.PrometheusServers = cfg.PrometheusServers
+		}
+		if len(c.WebhookAlertsTemplatePath) == 0 {
+			c.WebhookAlertsTemplatePath = cfg.WebhookAlertsTemplatePath
+		}
+		if len(c.GettableAlertsTemplatePath) == 0 {
+			c.GettableAlertsTemplatePath = cfg.GettableAlertsTemplatePath
+		}
+		if len(c.SilencesTemplatePath) == 0 {
+			c.SilencesTemplatePath = cfg.SilencesTemplatePath
+		}
+		if err := validateUsers(c.Users); err != nil {
+			return nil, fmt.Errorf("environment '%s': %s", c.Name, err)
+		}
+
+		alertmanagers, err := newAlertmanagers(c.Alertmanagers, c.AlermanagerURL, c.AlertmanagerHTTPConfig)
+		if err != nil {
+			return nil, fmt.Errorf("environment '%s': %s", c.Name, err)
+		}
+
+		promServers, err := newPrometheusServers(c.PrometheusServers, c.PrometheusURL, c.PrometheusHTTPConfig)
+		if err != nil {
+			return nil, fmt.Errorf("environment '%s': %s", c.Name, err)
+		}
+
+		environments = append(environments, &Environment{
+			Name:                       c.Name,
+			Alertmanagers:              alertmanagers,
+			Prometheus:                 promServers,
+			WebhookAlertsTemplatePath:  c.WebhookAlertsTemplatePath,
+			GettableAlertsTemplatePath: c.GettableAlertsTemplatePath,
+			SilencesTemplatePath:       c.SilencesTemplatePath,
+			Users:                      c.Users,
+		})
+	}
+
+	return
+}
+
+// isUserAllowed checks user access to environment by user id or username,
+// environment without users is allowed to every user with role
+func (env *Environment) isUserAllowed(u *tgbotapi.User) bool {
+	return len(env.Users) == 0 || matchesAnyUser(env.Users, u)
+}
+
+// getEnvironment returns environment by name or nil if not found
+func (bot *TelegramBot) getEnvironment(name string) *Environment {
+	for _, env := range cfg().environments {
+		if env.Name == name {
+			return env
+		}
+	}
+	return nil
+}
+
+// chatEnvironment returns environment selected in chat,
+// or the first configured one if nothing selected yet
+func (bot *TelegramBot) chatEnvironment(chatID int64) *Environment {
+	if env := bot.getEnvironment(bot.State.GetChatEnvironment(chatID)); env != nil {
+		return env
+	}
+	return cfg().environments[0]
+}
+
+// environmentHeader returns header for output of given environment
+// if more than one environment is configured
+func environmentHeader(bot *TelegramBot, env *Environment) string {
+	if len(cfg().environments) < 2 {
+		return ""
+	}
+	return fmt.Sprintf("[%s]\n", env.Name)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ReneKroon/ttlcache/v2"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+func TestNewEnvironments(t *testing.T) {
+	top := Config{
+		AlermanagerURL:             "http://am:9093",
+		PrometheusURL:              "http://prometheus:9090",
+		GettableAlertsTemplatePath: "alerts.tmpl",
+	}
+
+	tests := []struct {
+		name         string
+		environments []EnvironmentConfig
+		// environment name -> alertmanager urls, prometheus server names and alerts template
+		want    map[string][]string
+		wantErr bool
+	}{
+		{
+			name: "default environment",
+			want: map[string][]string{
+				"default": {"http://am:9093", "default", "alerts.tmpl"},
+			},
+		},
+		{
+			name: "inherited and overridden",
+			environments: []EnvironmentConfig{
+				{Name: "prod"},
+				{
+					Name:           "staging",
+					AlermanagerURL: "http://am-staging:9093",
+					PrometheusServers: []PrometheusConfig{
+						{Name: "staging", URL: "http://prometheus-staging:9090"},
+					},
+					GettableAlertsTemplatePath: "staging.tmpl",
+				},
+			},
+			want: map[string][]string{
+				"prod":    {"http://am:9093", "default", "alerts.tmpl"},
+				"staging": {"http://am-staging:9093", "staging", "staging.tmpl"},
+			},
+		},
+		{
+			name:         "name not set",
+			environments: []EnvironmentConfig{{AlermanagerURL: "http://am:9093"}},
+			wantErr:      true,
+		},
+		{
+			name:         "duplicate name",
+			environments: []EnvironmentConfig{{Name: "prod"}, {Name: "prod"}},
+			wantErr:      true,
+		},
+		{
+			name:         "empty user",
+			environments: []EnvironmentConfig{{Name: "prod", Users: []string{" "}}},
+			wantErr:      true,
+		},
+		{
+			name: "invalid alertmanager",
+			environments: []EnvironmentConfig{
+				{Name: "prod", Alertmanagers: []AlertmanagerConfig{{Name: "main"}}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := top
+			c.Environments = tt.environments
+
+			environments, err := newEnvironments(&c)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newEnvironments() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			got := make(map[string][]string)
+			for _, env := range environments {
+				got[env.Name] = []string{env.Alertmanagers[0].URL(), env.Prometheus[0].Name, env.GettableAlertsTemplatePath}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got environments %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatEnvironment(t *testing.T) {
+	prod := &Environment{Name: "prod"}
+	staging := &Environment{Name: "staging", Users: []string{"@alice", "42"}}
+	setTestConfig(t, func(c *Config) {
+		c.KeyboardRows = 2
+		c.environments = []*Environment{prod, staging}
+	})
+
+	state, err := loadState("")
+	if err != nil {
+		t.Fatalf("error loading state: %s", err)
+	}
+	cache := ttlcache.NewCache()
+	defer cache.Close()
+	bot := &TelegramBot{State: state, Cache: cache}
+
+	if env := bot.chatEnvironment(1); env != prod {
+		t.Errorf("got environment '%s' of chat without selected one, want prod", env.Name)
+	}
+	if err := state.SetChatEnvironment(1, "staging"); err != nil {
+		t.Fatalf("error saving chat environment: %s", err)
+	}
+	if env := bot.chatEnvironment(1); env != staging {
+		t.Errorf("got environment '%s' of chat, want staging", env.Name)
+	}
+	// environment removed from config
+	if err := state.SetChatEnvironment(1, "dev"); err != nil {
+		t.Fatalf("error saving chat environment: %s", err)
+	}
+	if env := bot.chatEnvironment(1); env != prod {
+		t.Errorf("got environment '%s' of chat with removed one, want prod", env.Name)
+	}
+
+	if h := environmentHeader(bot, staging); h != "[staging]\n" {
+		t.Errorf("got header %q, want %q", h, "[staging]\n")
+	}
+
+	tests := []struct {
+		name string
+		user *tgbotapi.User
+		want [][]string
+	}{
+		{
+			name: "allowed by username",
+			user: &tgbotapi.User{ID: 1, UserName: "Alice"},
+			want: [][]string{{"prod", "staging"}, {"Close menu"}},
+		},
+		{
+			name: "allowed by id",
+			user: &tgbotapi.User{ID: 42},
+			want: [][]string{{"prod", "staging"}, {"Close menu"}},
+		},
+		{
+			name: "not allowed",
+			user: &tgbotapi.User{ID: 2, UserName: "bob"},
+			want: [][]string{{"prod"}, {"Close menu"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := kbLabels(newEnvironmentsKB(bot, tt.user)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got keyboard %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
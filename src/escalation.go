@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/alertmanager/pkg/labels"
+	alerttmpl "github.com/prometheus/alertmanager/template"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// pending escalations are checked with this interval
+const escalationCheckInterval = 15 * time.Second
+
+// EscalationConfig is escalation policy from config file
+// firing alert group sent to its chats and matching its matchers
+// is escalated step by step until it's acked, silenced or resolved
+type EscalationConfig struct {
+	Name     string           `yaml:"name"`
+	Chats    []int64          `yaml:"chats"`
+	Matchers []string         `yaml:"matchers"`
+	Steps    []EscalationStep `yaml:"steps"`
+	// the last step is repeated with this interval, 0 - not repeated
+	RepeatInterval time.Duration `yaml:"repeat_interval"`
+}
+
+// EscalationStep is taken after wait since the previous step (or since the alert group was sent),
+// message is re-posted to chat and sent directly to users (user must start the bot first)
+// users are telegram user ids or usernames of users with role
+type EscalationStep struct {
+	After  time.Duration `yaml:"after"`
+	ChatID int64         `yaml:"chat_id"`
+	Users  []string      `yaml:"users"`
+}
+
+// EscalationPolicy is escalation policy with parsed matchers
+type EscalationPolicy struct {
+	EscalationConfig
+	matchers labels.Matchers
+}
+
+// PendingEscalation is escalation of alert group persisted in state
+type PendingEscalation struct {
+	Policy       string            `json:"policy"`
+	Env          string            `json:"env"`
+	Alertmanager string            `json:"alertmanager"`
+	Tenant       string            `json:"tenant,omitempty"`
+	ChatID       int64             `json:"chat_id"`
+	GroupLabels  map[string]string `json:"group_labels"`
+	Fingerprints []string          `json:"fingerprints"`
+	Text         string            `json:"text"`
+	ParseMode    string            `json:"parse_mode"`
+	Started      time.Time         `json:"started"`
+	// index of the next step
+	Step int       `json:"step"`
+	Next time.Time `json:"next"`
+}
+
+func newEscalationPolicies(configs []EscalationConfig) (policies []*EscalationPolicy, err error) {
+	names := make(map[string]bool)
+	for _, c := range configs {
+		if len(c.Name) == 0 {
+			return nil, fmt.Errorf("escalation policy name is not set")
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("duplicate escalation policy name '%s'", c.Name)
+		}
+		names[c.Name] = true
+
+		if len(c.Steps) == 0 {
+			return nil, fmt.Errorf("escalation policy '%s': no steps defined", c.Name)
+		}
+		for i, s := range c.Steps {
+			if s.After <= 0 {
+				return nil, fmt.Errorf("escalation policy '%s': step %d: after must be positive", c.Name, i+1)
+			}
+			if s.ChatID == 0 && len(s.Users) == 0 {
+				return nil, fmt.Errorf("escalation policy '%s': step %d: neither chat_id nor users are set", c.Name, i+1)
+			}
+			for _, u := range s.Users {
+				if _, err := strconv.ParseInt(u, 10, 64); err != nil && !usernameRegexp.MatchString(u) {
+					return nil, fmt.Errorf("escalation policy '%s': step %d: user '%s' is neither user id nor username", c.Name, i+1, u)
+				}
+			}
+		}
+
+		p := EscalationPolicy{
+			EscalationConfig: c,
+		}
+		for _, s := range c.Matchers {
+			m, err := labels.ParseMatcher(s)
+			if err != nil {
+				return nil, fmt.Errorf("escalation policy '%s': error parsing matcher '%s': %s", c.Name, s, err)
+			}
+			p.matchers = append(p.matchers, m)
+		}
+
+		policies = append(policies, &p)
+	}
+
+	return
+}
+
+// matches checks if policy applies to webhook sent to chat with given common labels
+func (p *EscalationPolicy) matches(chatID int64, kv alerttmpl.KV) bool {
+	if len(p.Chats) > 0 {
+		found := false
+		for _, c := range p.Chats {
+			if c == chatID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return p.matchers.Matches(toLabelSet(kv))
+}
+
+// getEscalationPolicy returns escalation policy by name or nil if not found
+func getEscalationPolicy(name string) *EscalationPolicy {
+	for _, p := range cfg().escalations {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// webhookEscalationPolicy returns the first escalation policy matching webhook or nil if none matched
+func webhookEscalationPolicy(chatID int64, data alerttmpl.Data) *EscalationPolicy {
+	for _, p := range cfg().escalations {
+		if p.matches(chatID, data.CommonLabels) {
+			return p
+		}
+	}
+	return nil
+}
+
+// updateEscalation starts escalation of unacked firing alert group,
+// updates of escalated group keep its current step, resolve cancels escalation
+func updateEscalation(bot *TelegramBot, groupKey string, chatID int64, env *Environment, am *Alertmanager, tenant *TenantConfig, data alerttmpl.Data, text string, parseMode string) {
+	if data.Status == "resolved" {
+		if err := bot.State.RemoveEscalation(groupKey); err != nil {
+			log.Printf("error removing escalation: %s", err)
+		}
+		return
+	}
+
+	p := webhookEscalationPolicy(chatID, data)
+	if p == nil || bot.State.GetAck(groupKey) != nil {
+		return
+	}
+
+	now := time.Now()
+	e := PendingEscalation{
+		Policy:       p.Name,
+		Env:          env.Name,
+		ChatID:       chatID,
+		GroupLabels:  data.GroupLabels,
+		Fingerprints: firingFingerprints(data),
+		Text:         text,
+		ParseMode:    parseMode,
+		Started:      now,
+		Next:         now.Add(p.Steps[0].After),
+	}
+	if am != nil {
+		e.Alertmanager = am.Name
+	}
+	if tenant != nil {
+		e.Tenant = tenant.Name
+	}
+
+	if err := bot.State.StartEscalation(groupKey, e); err != nil {
+		log.Printf("error saving escalation: %s", err)
+	}
+}
+
+// isSuppressed checks if all alerts of escalated group are silenced, inhibited or resolved
+// escalation goes on if alertmanager is unavailable
+func (e *PendingEscalation) isSuppressed(env *Environment) bool {
+	am := env.getAlertmanager(e.Alertmanager)
+	if am == nil {
+		return false
+	}
+
+	// alerts are looked up in tenant webhook was sent for
+	tenant := defaultTenant()
+	if len(e.Tenant) > 0 {
+		if tenant = getTenant(e.Tenant); tenant == nil {
+			return false
+		}
+	}
+
+	var filter []string
+	for k, v := range e.GroupLabels {
+		filter = append(filter, fmt.Sprintf("%s=%q", k, v))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg().APITimeout)
+	defer cancel()
+
+	alerts, err := am.GetAlerts(withTenant(ctx, tenant), filter)
+	if err != nil {
+		log.Printf("error getting alerts from alertmanager '%s': %s", am.Name, err)
+		return false
+	}
+
+	fingerprints := make(map[string]bool)
+	for _, fp := range e.Fingerprints {
+		fingerprints[fp] = true
+	}
+	for _, a := range alerts {
+		if a.Fingerprint != nil && fingerprints[*a.Fingerprint] && a.Status != nil && a.Status.State != nil && *a.Status.State == "active" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// escalate takes the next step of escalation
+// returns false if escalation is finished
+func escalate(bot *TelegramBot, groupKey string, e *PendingEscalation, p *EscalationPolicy) bool {
+	step := p.Steps[e.Step]
+
+	header := fmt.Sprintf("🔺 Escalation (step %d/%d): not acknowledged for %s", e.Step+1, len(p.Steps), time.Since(e.Started).Round(time.Minute))
+	if e.ParseMode == tgbotapi.ModeHTML {
+		header = "<b>" + header + "</b>"
+	}
+	text := header + "\n" + e.Text
+
+	var chats []int64
+	if step.ChatID != 0 {
+		chats = append(chats, step.ChatID)
+	}
+	for _, u := range step.Users {
+		chatID, ok := bot.userChatID(u)
+		if !ok {
+			log.Printf("escalation user '%s' not found, user must have role and start the bot first", u)
+			continue
+		}
+		chats = append(chats, chatID)
+	}
+
+	for _, chatID := range chats {
+		msg := tgbotapi.NewMessage(chatID, text)
+		msg.ParseMode = e.ParseMode
+
+		// escalated message could be acked or silenced as the original one
+		if g := bot.State.GetAlertGroup(groupID(groupKey)); g != nil {
+			kb := groupKeyboard(g, false)
+			msg.ReplyMarkup = &kb
+		}
+
+		// escalations are urgent, so they are sent first like replies to commands
+		// escalations of other groups don't wait for chat rate limits
+		done := queueMessage(bot, msg, priorityInteractive, "")
+		go func(chatID int64) {
+			if err := <-done; err != nil {
+				log.Printf("error sending escalation of %s to %d: %s", groupKey, chatID, err)
+			}
+		}(chatID)
+	}
+	log.Printf("alert group %s escalated by policy '%s', step %d", groupKey, p.Name, e.Step+1)
+
+	switch {
+	case e.Step+1 < len(p.Steps):
+		e.Step++
+		e.Next = time.Now().Add(p.Steps[e.Step].After)
+	case p.RepeatInterval > 0:
+		e.Next = time.Now().Add(p.RepeatInterval)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// processEscalations takes due steps of pending escalations
+// escalations of acked and suppressed groups are cancelled
+func processEscalations(bot *TelegramBot) {
+	now := time.Now()
+	for groupKey, e := range bot.State.GetEscalations() {
+		if e.Next.After(now) {
+			continue
+		}
+
+		// policy or environment could be removed by config reload
+		p := getEscalationPolicy(e.Policy)
+		env := bot.getEnvironment(e.Env)
+		if p == nil || env == nil || e.Step >= len(p.Steps) || bot.State.GetAck(groupKey) != nil || e.isSuppressed(env) {
+			log.Printf("escalation of alert group %s cancelled", groupKey)
+			if err := bot.State.RemoveEscalation(groupKey); err != nil {
+				log.Printf("error removing escalation: %s", err)
+			}
+			continue
+		}
+
+		var err error
+		if escalate(bot, groupKey, e, p) {
+			err = bot.State.SetEscalation(groupKey, *e)
+		} else {
+			err = bot.State.RemoveEscalation(groupKey)
+		}
+		if err != nil {
+			log.Printf("error saving escalation: %s", err)
+		}
+	}
+}
+
+// monitorEscalations periodically processes pending escalations
+// escalations are kept in state, so they are continued after restart
+func monitorEscalations(bot *TelegramBot) {
+	for {
+		processEscalations(bot)
+		time.Sleep(escalationCheckInterval)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	alerttmpl "github.com/prometheus/alertmanager/template"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+func TestNewEscalationPolicies(t *testing.T) {
+	step := EscalationStep{After: time.Minute, ChatID: -1}
+
+	tests := []struct {
+		name    string
+		config  EscalationConfig
+		wantErr bool
+	}{
+		{name: "valid", config: EscalationConfig{Name: "a", Matchers: []string{`severity="critical"`}, Steps: []EscalationStep{step}}},
+		{name: "users by id and username", config: EscalationConfig{Name: "a", Steps: []EscalationStep{{After: time.Minute, Users: []string{"123456789", "@alice_1", "bob_2"}}}}},
+		{name: "no name", config: EscalationConfig{Steps: []EscalationStep{step}}, wantErr: true},
+		{name: "no steps", config: EscalationConfig{Name: "a"}, wantErr: true},
+		{name: "zero after", config: EscalationConfig{Name: "a", Steps: []EscalationStep{{ChatID: -1}}}, wantErr: true},
+		{name: "no chat and users", config: EscalationConfig{Name: "a", Steps: []EscalationStep{{After: time.Minute}}}, wantErr: true},
+		{name: "wrong user", config: EscalationConfig{Name: "a", Steps: []EscalationStep{{After: time.Minute, Users: []string{"<bob>"}}}}, wantErr: true},
+		{name: "wrong matcher", config: EscalationConfig{Name: "a", Matchers: []string{"severity"}, Steps: []EscalationStep{step}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newEscalationPolicies([]EscalationConfig{tt.config}); (err != nil) != tt.wantErr {
+				t.Errorf("newEscalationPolicies() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := newEscalationPolicies([]EscalationConfig{{Name: "a", Steps: []EscalationStep{step}}, {Name: "a", Steps: []EscalationStep{step}}}); err == nil {
+		t.Errorf("newEscalationPolicies() expected error for duplicate names")
+	}
+}
+
+func TestEscalationPolicyMatches(t *testing.T) {
+	policies, err := newEscalationPolicies([]EscalationConfig{{
+		Name:     "critical",
+		Chats:    []int64{-1},
+		Matchers: []string{`severity="critical"`},
+		Steps:    []EscalationStep{{After: time.Minute, ChatID: -2}},
+	}})
+	if err != nil {
+		t.Fatalf("error creating policies: %s", err)
+	}
+	p := policies[0]
+
+	tests := []struct {
+		name   string
+		chatID int64
+		labels alerttmpl.KV
+		want   bool
+	}{
+		{"matching chat and labels", -1, alerttmpl.KV{"severity": "critical"}, true},
+		{"other chat", -3, alerttmpl.KV{"severity": "critical"}, false},
+		{"other labels", -1, alerttmpl.KV{"severity": "warning"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.matches(tt.chatID, tt.labels); got != tt.want {
+				t.Errorf("matches() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateEscalation(t *testing.T) {
+	policies, err := newEscalationPolicies([]EscalationConfig{{
+		Name:  "all",
+		Steps: []EscalationStep{{After: time.Minute, ChatID: -2}},
+	}})
+	if err != nil {
+		t.Fatalf("error creating policies: %s", err)
+	}
+	setTestConfig(t, func(c *Config) {
+		c.escalations = policies
+	})
+
+	state, err := loadState("")
+	if err != nil {
+		t.Fatalf("error loading state: %s", err)
+	}
+	bot := &TelegramBot{State: state}
+	env := &Environment{Name: "prod"}
+	tenant := &TenantConfig{Name: "team"}
+
+	firing := alerttmpl.Data{Status: "firing", Alerts: alerttmpl.Alerts{{Status: "firing", Fingerprint: "a"}}}
+	updateEscalation(bot, "g", -1, env, nil, tenant, firing, "text", "")
+	e := state.GetEscalations()["g"]
+	if e == nil {
+		t.Fatalf("escalation is not started")
+	}
+	if e.Policy != "all" || e.Tenant != "team" || e.Step != 0 {
+		t.Errorf("got escalation %+v", e)
+	}
+
+	// update keeps current step
+	e.Step = 1
+	if err := state.SetEscalation("g", *e); err != nil {
+		t.Fatalf("error saving escalation: %s", err)
+	}
+	updateEscalation(bot, "g", -1, env, nil, tenant, firing, "text", "")
+	if e := state.GetEscalations()["g"]; e == nil || e.Step != 1 {
+		t.Errorf("escalation step is not kept: %+v", e)
+	}
+
+	// acked group is not escalated
+	if err := state.SetAck("h", Ack{}); err != nil {
+		t.Fatalf("error saving ack: %s", err)
+	}
+	updateEscalation(bot, "h", -1, env, nil, tenant, firing, "text", "")
+	if e := state.GetEscalations()["h"]; e != nil {
+		t.Errorf("acked group is escalated")
+	}
+
+	updateEscalation(bot, "g", -1, env, nil, tenant, alerttmpl.Data{Status: "resolved"}, "text", "")
+	if e := state.GetEscalations()["g"]; e != nil {
+		t.Errorf("escalation of resolved group is not cancelled")
+	}
+}
+
+func TestUserChatID(t *testing.T) {
+	setTestConfig(t, func(c *Config) {
+		c.RBAC.Operators = []string{"@carol_1"}
+	})
+
+	state, err := loadState("")
+	if err != nil {
+		t.Fatalf("error loading state: %s", err)
+	}
+	bot := &TelegramBot{State: state}
+
+	if err := state.SetApprovedUser(ApprovedUser{ID: 1, UserName: "alice_1", Role: roleViewer}); err != nil {
+		t.Fatalf("error saving approved user: %s", err)
+	}
+	for _, u := range []*tgbotapi.User{{ID: 2, UserName: "Carol_1"}, {ID: 3, UserName: "dave_1"}} {
+		if err := state.SetUserChat(u, int64(u.ID)); err != nil {
+			t.Fatalf("error saving user chat: %s", err)
+		}
+	}
+
+	tests := []struct {
+		user   string
+		want   int64
+		wantOK bool
+	}{
+		{"123456789", 123456789, true},
+		{"@alice_1", 1, true},
+		{"@ALICE_1", 1, true},
+		{"carol_1", 2, true},
+		// started the bot, but has no role
+		{"@dave_1", 0, false},
+		{"@erin_1", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := bot.userChatID(tt.user)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("userChatID(%s) = %d, %t, want %d, %t", tt.user, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// apiHealth tracks availability of api endpoint
+type apiHealth struct {
+	mtx   sync.Mutex
+	down  bool
+	since time.Time
+}
+
+// update records result of api request, returns true if availability changed
+func (h *apiHealth) update(err error) bool {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if (err != nil) == h.down {
+		return false
+	}
+
+	h.down = err != nil
+	h.since = time.Now()
+	return true
+}
+
+// unreachableSince returns time since endpoint is down, ok is false if it's up
+func (h *apiHealth) unreachableSince() (since time.Time, ok bool) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	return h.since, h.down
+}
+
+func unreachableMessage(kind, name string, since time.Time) string {
+	return fmt.Sprintf("%s '%s' unreachable since %s (%s ago).", kind, name, FormatDate(since), time.Since(since).Round(time.Second))
+}
+
+// alertmanagersDown returns message about unreachable alertmanagers of environment
+// all is true if none of them is available
+func (env *Environment) alertmanagersDown() (msg string, all bool) {
+	var down int
+	for _, am := range env.Alertmanagers {
+		if since, ok := am.unreachableSince(); ok {
+			msg += unreachableMessage("Alertmanager", am.Name, since) + "\n"
+			down++
+		}
+	}
+	return msg, down == len(env.Alertmanagers)
+}
+
+// prometheusDown returns message about unreachable prometheus servers of environment
+// all is true if none of them is available
+func (env *Environment) prometheusDown() (msg string, all bool) {
+	var down int
+	for _, srv := range env.Prometheus {
+		if since, ok := srv.unreachableSince(); ok {
+			msg += unreachableMessage("Prometheus", srv.Name, since) + "\n"
+			down++
+		}
+	}
+	return msg, down == len(env.Prometheus)
+}
+
+// checkHealth requests status of every alertmanager & prometheus server
+func checkHealth(bot *TelegramBot) {
+	for _, env := range cfg().environments {
+		for _, am := range env.Alertmanagers {
+			ctx, cancel := context.WithTimeout(context.Background(), cfg().APITimeout)
+			ctx = withTenant(ctx, defaultTenant())
+			if _, err := am.GetStatus(ctx); err != nil {
+				log.Printf("error getting alertmanager '%s/%s' status: %s", env.Name, am.Name, err)
+			}
+			cancel()
+		}
+
+		for _, srv := range env.Prometheus {
+			ctx, cancel := context.WithTimeout(context.Background(), cfg().APITimeout)
+			ctx = withTenant(ctx, defaultTenant())
+			srv.checkHealth(ctx)
+			cancel()
+		}
+	}
+}
+
+// monitorHealth periodically checks api endpoints availability,
+// so commands could be answered with unavailability message right away
+func monitorHealth(bot *TelegramBot) {
+	for {
+		checkHealth(bot)
+
+		// interval is read on every iteration to pick up config reloads
+		time.Sleep(cfg().HealthCheckInterval)
+	}
+}
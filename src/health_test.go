@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAPIHealthUpdate(t *testing.T) {
+	var h apiHealth
+	errDown := errors.New("down")
+
+	steps := []struct {
+		err         error
+		wantChanged bool
+		wantDown    bool
+	}{
+		{err: nil, wantChanged: false, wantDown: false},
+		{err: errDown, wantChanged: true, wantDown: true},
+		{err: errDown, wantChanged: false, wantDown: true},
+		{err: nil, wantChanged: true, wantDown: false},
+	}
+
+	for i, s := range steps {
+		if changed := h.update(s.err); changed != s.wantChanged {
+			t.Errorf("step %d: update() = %v, want %v", i, changed, s.wantChanged)
+		}
+		if _, down := h.unreachableSince(); down != s.wantDown {
+			t.Errorf("step %d: unreachableSince() down = %v, want %v", i, down, s.wantDown)
+		}
+	}
+}
+
+func TestAPIHealthSince(t *testing.T) {
+	var h apiHealth
+
+	h.update(errors.New("down"))
+	since, _ := h.unreachableSince()
+
+	// repeated failures keep the time endpoint went down
+	h.update(errors.New("still down"))
+	if again, _ := h.unreachableSince(); !again.Equal(since) {
+		t.Errorf("unreachableSince() = %s, want %s", again, since)
+	}
+}
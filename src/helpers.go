@@ -1,120 +1,270 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"html/template"
-	"io"
 	"log"
-	"path"
-	"reflect"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/go-openapi/strfmt"
-	"github.com/prometheus/alertmanager/api/v2/client/alert"
-	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/alertmanager/api/v2/models"
 	"github.com/segmentio/ksuid"
 	tgbotapi "gopkg.in/telegram-bot-api.v4"
-	"gopkg.in/tucnak/telebot.v2"
 )
 
-const maxMessageTextLength = 4096
+const (
+	maxMessageTextLength  = 4096
+	keyboardLettersPerRow = 8
+	unlabelledJobName     = "Other / unlabelled"
+)
 
-var tmplFuncMap = template.FuncMap{
-	"ToUpper":    strings.ToUpper,
-	"ToLower":    strings.ToLower,
-	"KindOf":     KindOf,
-	"FormatDate": FormatDate,
+// kbEntry is a single item of a paged inline keyboard
+type kbEntry struct {
+	Name     string
+	Failing  bool
+	Callback Callback
 }
 
-func KindOf(in interface{}) string {
-	return reflect.TypeOf(in).Kind().String()
+func newCallbackWithPage(nav Callback, page int) Callback {
+	cb := Callback{
+		Type: nav.Type,
+		Data: make(map[string]string),
+	}
+	for k, v := range nav.Data {
+		cb.Data[k] = v
+	}
+	cb.Data["page"] = strconv.Itoa(page)
+	return cb
 }
 
-func FormatDate(in interface{}) (out string) {
-	var t time.Time
-	switch in := in.(type) {
-	case time.Time:
-		t = in
-	case *strfmt.DateTime:
-		t = time.Time(*in)
+// failingFunc returns names of entries with active alerts
+type failingFunc func(names []string) (map[string]bool, error)
+
+// setFailing sets state of entries not known to be failing with failing func
+func setFailing(entries []kbEntry, failing failingFunc) error {
+	if failing == nil {
+		return nil
 	}
 
-	loc, err := time.LoadLocation(cfg.TimeZone)
+	var names []string
+	for _, e := range entries {
+		if !e.Failing {
+			names = append(names, e.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	m, err := failing(names)
 	if err != nil {
-		log.Printf("error loading timezone %s: %s\n", cfg.TimeZone, err)
-		return
+		return err
+	}
+	for i := range entries {
+		if m[entries[i].Name] {
+			entries[i].Failing = true
+		}
 	}
 
-	out = t.In(loc).Format(cfg.TimeFormat)
-	return
+	return nil
 }
 
-func applyTemplate(in interface{}, templatePath string) (string, error) {
-	tmpl, err := template.New(path.Base(templatePath)).Funcs(tmplFuncMap).ParseFiles(templatePath)
-	if err != nil {
-		log.Printf("error loading template file: %s", err)
-		return "", err
+// newPagedKB creates inline keyboard with one page of entries,
+// Prev / Next buttons and alphabetical jump buttons
+// nav callback is used for switching pages
+// state of entries is requested with failing func only for the page shown,
+// unless failing entries are listed first
+func newPagedKB(bot *TelegramBot, entries []kbEntry, page int, nav Callback, failing failingFunc) (kb tgbotapi.InlineKeyboardMarkup, e error) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return strings.ToLower(entries[i].Name) < strings.ToLower(entries[j].Name)
+	})
+	if cfg().KeyboardFailingFirst {
+		// order depends on state of every entry
+		if e = setFailing(entries, failing); e != nil {
+			return
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].Failing && !entries[j].Failing
+		})
 	}
 
-	b := bytes.Buffer{}
-	w := io.Writer(&b)
-	err = tmpl.Execute(w, in)
-	if err != nil {
-		log.Printf("error executing template: %s", err)
-		return "", err
+	pages := 1
+	pageSize := cfg().KeyboardPageSize
+	if pageSize > 0 && len(entries) > pageSize {
+		pages = (len(entries) + pageSize - 1) / pageSize
+	} else {
+		pageSize = len(entries)
+	}
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
 	}
 
-	return b.String(), nil
-}
+	first := page * pageSize
+	last := first + pageSize
+	if last > len(entries) {
+		last = len(entries)
+	}
 
-func newJobsKB(bot *TelegramBot) (kb tgbotapi.InlineKeyboardMarkup, e error) {
-	// api call timeout
-	ctx, cancel := context.WithTimeout(context.Background(), cfg.APITimeout)
-	defer cancel()
+	if !cfg().KeyboardFailingFirst {
+		if e = setFailing(entries[first:last], failing); e != nil {
+			return
+		}
+	}
 
-	v1api := v1.NewAPI(bot.Prometheus)
-	labels, _, err := v1api.LabelValues(ctx, "job", []string{}, time.Now().Add(-time.Minute), time.Now())
-	if err != nil {
-		e = fmt.Errorf("error getting jobs: %s", err)
+	r := tgbotapi.NewInlineKeyboardRow()
+	for _, e := range entries[first:last] {
+		var btnLabel string
+		if e.Failing {
+			btnLabel = cfg().ButtonPrefixFail + e.Name
+		} else {
+			btnLabel = cfg().ButtonPrefixOK + e.Name
+		}
+
+		// create new cache entry
+		cacheID := ksuid.New().String()
+		bot.Cache.Set(cacheID, e.Callback)
+
+		r = append(r, tgbotapi.NewInlineKeyboardButtonData(btnLabel, cacheID))
+		if len(r) == cfg().KeyboardRows {
+			kb.InlineKeyboard = append(kb.InlineKeyboard, r)
+			r = tgbotapi.NewInlineKeyboardRow()
+		}
+	}
+
+	if len(r) > 0 {
+		kb.InlineKeyboard = append(kb.InlineKeyboard, r)
+	}
+
+	// everything fits on one page, no navigation needed
+	if pages <= 1 {
 		return
 	}
 
+	// Prev / Next buttons
+	r = tgbotapi.NewInlineKeyboardRow()
+	if page > 0 {
+		cacheID := ksuid.New().String()
+		bot.Cache.Set(cacheID, newCallbackWithPage(nav, page-1))
+		r = append(r, tgbotapi.NewInlineKeyboardButtonData("« Prev", cacheID))
+	}
+	if page < pages-1 {
+		cacheID := ksuid.New().String()
+		bot.Cache.Set(cacheID, newCallbackWithPage(nav, page+1))
+		r = append(r, tgbotapi.NewInlineKeyboardButtonData("Next »", cacheID))
+	}
+	kb.InlineKeyboard = append(kb.InlineKeyboard, r)
+
+	// alphabetical jump buttons, pointing to the page
+	// with the first entry starting with given letter
+	// failing entries listed first are out of alphabetical order,
+	// so they are pointed to only by letters missing in alphabetical part
+	var letters []string
+	letterPages := make(map[string]int)
+	for _, alphabetical := range []bool{true, false} {
+		for i, e := range entries {
+			if len(e.Name) == 0 || (cfg().KeyboardFailingFirst && e.Failing == alphabetical) {
+				continue
+			}
+			l := strings.ToUpper(string([]rune(e.Name)[0]))
+			if _, ok := letterPages[l]; ok {
+				continue
+			}
+			letterPages[l] = i / pageSize
+			letters = append(letters, l)
+		}
+	}
+	sort.Strings(letters)
+
+	if len(letters) < 2 {
+		return
+	}
+
+	r = tgbotapi.NewInlineKeyboardRow()
+	for _, l := range letters {
+		cacheID := ksuid.New().String()
+		bot.Cache.Set(cacheID, newCallbackWithPage(nav, letterPages[l]))
+
+		r = append(r, tgbotapi.NewInlineKeyboardButtonData(l, cacheID))
+		if len(r) == keyboardLettersPerRow {
+			kb.InlineKeyboard = append(kb.InlineKeyboard, r)
+			r = tgbotapi.NewInlineKeyboardRow()
+		}
+	}
+
+	if len(r) > 0 {
+		kb.InlineKeyboard = append(kb.InlineKeyboard, r)
+	}
+
+	return
+}
+
+// newEnvironmentsKB creates inline keyboard with environments available for user
+func newEnvironmentsKB(bot *TelegramBot, user *tgbotapi.User) (kb tgbotapi.InlineKeyboardMarkup) {
 	r := tgbotapi.NewInlineKeyboardRow()
-	for _, l := range labels {
-		al, err := bot.Alertmanager.Alert.GetAlerts(&alert.GetAlertsParams{
-			Filter:  []string{"job=" + string(l)},
-			Context: ctx,
-		})
-		if err != nil {
-			// e = fmt.Errorf("error getting alerts for job '%s': %s", string(l), e)
-			// return
-			log.Printf("error getting alerts for job '%s': %s", string(l), err)
+	for _, env := range cfg().environments {
+		if !env.isUserAllowed(user) {
 			continue
 		}
 
-		var btnLabel string
-		if len(al.GetPayload()) == 0 {
-			btnLabel = cfg.ButtonPrefixOK + string(l)
-		} else {
-			btnLabel = cfg.ButtonPrefixFail + string(l)
+		// create new cache entry
+		cacheID := ksuid.New().String()
+		newCallback := Callback{
+			Type: "env",
+			Data: make(map[string]string),
+		}
+		newCallback.Data["env"] = env.Name
+		bot.Cache.Set(cacheID, newCallback)
+
+		r = append(r, tgbotapi.NewInlineKeyboardButtonData(env.Name, cacheID))
+		if len(r) == cfg().KeyboardRows {
+			kb.InlineKeyboard = append(kb.InlineKeyboard, r)
+			r = tgbotapi.NewInlineKeyboardRow()
+		}
+	}
+
+	if len(r) > 0 {
+		kb.InlineKeyboard = append(kb.InlineKeyboard, r)
+	}
+
+	// create new cache entry
+	cacheID := ksuid.New().String()
+	newCallback := Callback{
+		Type: "close",
+	}
+	bot.Cache.Set(cacheID, newCallback)
+
+	// button with request to delete message (close menu)
+	kb.InlineKeyboard = append(kb.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Close menu", cacheID)))
+
+	return
+}
+
+// newTenantsKB creates inline keyboard with tenants available for user
+func newTenantsKB(bot *TelegramBot, user *tgbotapi.User) (kb tgbotapi.InlineKeyboardMarkup) {
+	r := tgbotapi.NewInlineKeyboardRow()
+	for _, t := range cfg().Tenants {
+		if !t.isUserAllowed(user) {
+			continue
 		}
 
 		// create new cache entry
 		cacheID := ksuid.New().String()
 		newCallback := Callback{
-			Type: "job",
+			Type: "tenant",
 			Data: make(map[string]string),
 		}
-		newCallback.Data["job_name"] = string(l)
+		newCallback.Data["tenant"] = t.Name
 		bot.Cache.Set(cacheID, newCallback)
 
-		r = append(r, tgbotapi.NewInlineKeyboardButtonData(btnLabel, cacheID))
-		if len(r) == cfg.KeyboardRows {
+		r = append(r, tgbotapi.NewInlineKeyboardButtonData(t.Name, cacheID))
+		if len(r) == cfg().KeyboardRows {
 			kb.InlineKeyboard = append(kb.InlineKeyboard, r)
 			r = tgbotapi.NewInlineKeyboardRow()
 		}
@@ -137,140 +287,445 @@ func newJobsKB(bot *TelegramBot) (kb tgbotapi.InlineKeyboardMarkup, e error) {
 	return
 }
 
-func newTargetsKB(bot *TelegramBot, jobName string) (kb tgbotapi.InlineKeyboardMarkup, e error) {
-	// api call timeout
-	ctx, cancel := context.WithTimeout(context.Background(), cfg.APITimeout)
-	defer cancel()
+// getAlertLabelValues returns number of active alerts per value of the given label
+// alerts without this label are counted under empty string key
+func getAlertLabelValues(ctx context.Context, env *Environment, label string, filter []string) (map[string]int, error) {
+	al, err := env.getAlerts(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]int)
+	for _, a := range al {
+		values[a.Labels[label]]++
+	}
+
+	return values, nil
+}
 
-	v1api := v1.NewAPI(bot.Prometheus)
-	targets, err := v1api.Targets(ctx)
+// getAlertingValues returns values of the given label having active alerts matching filter
+func getAlertingValues(ctx context.Context, env *Environment, label string, filter []string, values []string) (map[string]bool, error) {
+	counts, err := getAlertLabelValues(ctx, env, label, append(filter, labelFilter(label, "=~", values)))
 	if err != nil {
-		e = fmt.Errorf("error getting targets data: %s", err)
-		return
+		return nil, fmt.Errorf("error getting alerts: %s", err)
 	}
 
-	// sort targets by instance label
-	sort.Slice(targets.Active, func(i, j int) bool {
-		return targets.Active[i].Labels["instance"] < targets.Active[j].Labels["instance"]
-	})
+	alerting := make(map[string]bool)
+	for v, n := range counts {
+		alerting[v] = n > 0
+	}
+	return alerting, nil
+}
 
-	r := tgbotapi.NewInlineKeyboardRow()
-	for _, t := range targets.Active {
-		if len(t.Labels["job"]) == 0 || len(t.Labels["instance"]) == 0 {
-			b, err := json.Marshal(t)
-			if err != nil {
-				// return fmt.Errorf("could not get labels [job, instance] for target '%v'", t)
-				log.Printf("could not get labels [job, instance] for target '%v'", t)
-				continue
+// labelFilter returns alertmanager filter matching label with regexp of any of values,
+// values are escaped for regexp and quoted matcher value
+func labelFilter(label string, op string, values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = regexp.QuoteMeta(v)
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return label + op + `"` + r.Replace(strings.Join(quoted, "|")) + `"`
+}
+
+// formatAlerts returns active alerts matching filter from every alertmanager
+// formatted with gettable alerts template or as json
+// text is notes of unreachable alertmanagers if no alerts found
+func formatAlerts(ctx context.Context, bot *TelegramBot, env *Environment, filter []string, asJSON bool) (text string, count int, e error) {
+	var notes string
+	outputs := make(map[string]interface{})
+	for _, am := range env.Alertmanagers {
+		alerts, err := am.GetAlerts(ctx, filter)
+		if err != nil {
+			if len(env.Alertmanagers) == 1 {
+				e = fmt.Errorf("error getting alerts from alertmanager '%s': %s", am.Name, err)
+				return
 			}
-			// return fmt.Errorf("could not get labels [job, instance] for target '%s'", string(b))
-			log.Printf("could not get labels [job, instance] for target '%s'", string(b))
+
+			// skip unreachable alertmanager, if there are others
+			log.Printf("error getting alerts from alertmanager '%s': %s", am.Name, err)
+			since, _ := am.unreachableSince()
+			notes += unreachableMessage("Alertmanager", am.Name, since) + "\n"
+			outputs[am.Name] = map[string]interface{}{"error": err.Error()}
+			continue
 		}
 
-		// target job differs from the one requested in callback, skipping
-		if string(t.Labels["job"]) != jobName {
+		if len(alerts) == 0 {
+			outputs[am.Name] = map[string]interface{}{"alerts": models.GettableAlerts{}}
 			continue
 		}
+		count += len(alerts)
 
-		al, err := bot.Alertmanager.Alert.GetAlerts(&alert.GetAlertsParams{
-			Filter:  []string{"instance=" + string(t.Labels["instance"])},
-			Context: ctx,
-		})
-		if err != nil {
-			// return fmt.Errorf("error getting alerts for target '%s': %s", string(t.Labels["instance"]), err)
-			log.Printf("error getting alerts for target '%s': %s", string(t.Labels["instance"]), err)
+		if asJSON {
+			outputs[am.Name] = map[string]interface{}{"alerts": alerts}
 			continue
 		}
 
-		var btnLabel string
-		if len(al.GetPayload()) == 0 {
-			btnLabel = cfg.ButtonPrefixOK + string(t.Labels["instance"])
-		} else {
-			btnLabel = cfg.ButtonPrefixFail + string(t.Labels["instance"])
+		s, err := applyTemplate(alerts, env.GettableAlertsTemplatePath, externalURLFunc(am.URL()), queryFuncs(ctx, env), ackFuncs(bot), oncallFuncs(bot))
+		if err != nil {
+			e = fmt.Errorf("error applying template: %s", err)
+			return
+		}
+		text += alertmanagerHeader(env, am) + s + "\n"
+	}
+
+	switch {
+	case count == 0:
+		text = notes
+	case asJSON:
+		text, e = alertmanagersJSON(env, outputs, "alerts")
+	default:
+		text = notes + text
+	}
+
+	return
+}
+
+// alertmanagersJSON marshals outputs of alertmanagers as one json document:
+// items of the only alertmanager as is, otherwise object keyed by alertmanager name
+// with items or error of every alertmanager
+func alertmanagersJSON(env *Environment, outputs map[string]interface{}, items string) (string, error) {
+	var v interface{} = outputs
+	if len(env.Alertmanagers) == 1 {
+		v = outputs[env.Alertmanagers[0].Name].(map[string]interface{})[items]
+	}
+
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("error marshalling %s: %s", items, err)
+	}
+	return string(b), nil
+}
+
+func newServersKB(ctx context.Context, bot *TelegramBot, env *Environment, page int) (kb tgbotapi.InlineKeyboardMarkup, e error) {
+	var entries []kbEntry
+	for _, srv := range env.Prometheus {
+		al, err := env.getAlerts(ctx, srv.AlertFilter())
+		if err != nil {
+			e = fmt.Errorf("error getting alerts for server '%s': %s", srv.Name, err)
+			return
 		}
 
-		// create new cache entry
-		cacheID := ksuid.New().String()
 		newCallback := Callback{
-			Type: "target",
+			Type: "jobs",
 			Data: make(map[string]string),
 		}
-		newCallback.Data["job_name"] = jobName
-		newCallback.Data["target_name"] = string(t.Labels["instance"])
-		bot.Cache.Set(cacheID, newCallback)
+		newCallback.Data["env"] = env.Name
+		newCallback.Data["server"] = srv.Name
 
-		r = append(r, tgbotapi.NewInlineKeyboardButtonData(btnLabel, cacheID))
-		if len(r) == cfg.KeyboardRows {
-			kb.InlineKeyboard = append(kb.InlineKeyboard, r)
-			r = tgbotapi.NewInlineKeyboardRow()
-		}
+		entries = append(entries, kbEntry{
+			Name:     srv.Name,
+			Failing:  len(al) > 0,
+			Callback: newCallback,
+		})
 	}
 
-	if len(r) > 0 {
-		kb.InlineKeyboard = append(kb.InlineKeyboard, r)
+	nav := Callback{
+		Type: "servers",
+		Data: make(map[string]string),
 	}
+	nav.Data["env"] = env.Name
+
+	kb, e = newPagedKB(bot, entries, page, nav, nil)
+	if e != nil {
+		return
+	}
+
+	// create new cache entry
+	cacheID := ksuid.New().String()
+	newCallback := Callback{
+		Type: "close",
+	}
+	bot.Cache.Set(cacheID, newCallback)
+
+	// button with request to delete message (close menu)
+	kb.InlineKeyboard = append(kb.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Close menu", cacheID)))
 
 	return
 }
 
-func splitStringIntoChunks(text string) (chunks []string) {
-	var chunk string
-	splitted := strings.Split(text, "\n")
+func newJobsKB(ctx context.Context, bot *TelegramBot, env *Environment, srv *PrometheusServer, page int) (kb tgbotapi.InlineKeyboardMarkup, e error) {
+	labels, _, err := srv.API.LabelValues(ctx, "job", []string{}, time.Now().Add(-time.Minute), time.Now())
+	if err != nil {
+		e = fmt.Errorf("error getting jobs: %s", err)
+		return
+	}
+
+	// jobs of active alerts without prometheus target (blackbox, pushgateway, etc.)
+	// and alerts without job, state of other jobs is requested for the page shown
+	jobs := make(map[string]bool)
+	var known []string
+	for _, l := range labels {
+		jobs[string(l)] = false
+		known = append(known, string(l))
+	}
+	filter := srv.AlertFilter()
+	if len(known) > 0 {
+		filter = append(filter, labelFilter("job", "!~", known))
+	}
+	alertJobs, err := getAlertLabelValues(ctx, env, "job", filter)
+	if err != nil {
+		e = fmt.Errorf("error getting alerts: %s", err)
+		return
+	}
+	for j := range alertJobs {
+		if len(j) > 0 {
+			jobs[j] = true
+		}
+	}
+
+	var entries []kbEntry
+	for j, failing := range jobs {
+		newCallback := Callback{
+			Type: "job",
+			Data: make(map[string]string),
+		}
+		newCallback.Data["env"] = env.Name
+		newCallback.Data["server"] = srv.Name
+		newCallback.Data["job_name"] = j
+		newCallback.Data["jobs_page"] = strconv.Itoa(page)
+
+		entries = append(entries, kbEntry{
+			Name:     j,
+			Failing:  failing,
+			Callback: newCallback,
+		})
+	}
+
+	nav := Callback{
+		Type: "jobs",
+		Data: make(map[string]string),
+	}
+	nav.Data["env"] = env.Name
+	nav.Data["server"] = srv.Name
+
+	kb, e = newPagedKB(bot, entries, page, nav, func(names []string) (map[string]bool, error) {
+		return getAlertingValues(ctx, env, "job", srv.AlertFilter(), names)
+	})
+	if e != nil {
+		return
+	}
 
-	for _, s := range splitted {
-		if len(chunk+"\n"+s) > maxMessageTextLength {
-			chunks = append(chunks, chunk)
-			chunk = ""
+	// alerts without job label
+	if alertJobs[""] > 0 {
+		cacheID := ksuid.New().String()
+		newCallback := Callback{
+			Type: "job",
+			Data: make(map[string]string),
 		}
-		chunk = chunk + "\n" + s
+		newCallback.Data["env"] = env.Name
+		newCallback.Data["server"] = srv.Name
+		newCallback.Data["unlabelled"] = "yes"
+		newCallback.Data["jobs_page"] = strconv.Itoa(page)
+		bot.Cache.Set(cacheID, newCallback)
+
+		btnLabel := cfg().ButtonPrefixFail + unlabelledJobName
+		kb.InlineKeyboard = append(kb.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(btnLabel, cacheID)))
+	}
+
+	// go back to servers menu
+	if len(env.Prometheus) > 1 {
+		cacheID := ksuid.New().String()
+		newCallback := Callback{
+			Type: "servers",
+			Data: make(map[string]string),
+		}
+		newCallback.Data["env"] = env.Name
+		bot.Cache.Set(cacheID, newCallback)
+
+		kb.InlineKeyboard = append(kb.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Go back", cacheID)))
+	}
+
+	// create new cache entry
+	cacheID := ksuid.New().String()
+	newCallback := Callback{
+		Type: "close",
 	}
-	chunks = append(chunks, chunk)
+	bot.Cache.Set(cacheID, newCallback)
+
+	// button with request to delete message (close menu)
+	kb.InlineKeyboard = append(kb.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Close menu", cacheID)))
+
 	return
 }
 
-func sendMessage(bot *TelegramBot, c tgbotapi.Chattable) (err error) {
-	send := func(m tgbotapi.Chattable) (err error) {
-		for i := 0; i < cfg.SendMessageRetryCount; i++ {
-			_, err = bot.BotAPI.Send(m)
+func newTargetsKB(ctx context.Context, bot *TelegramBot, env *Environment, srv *PrometheusServer, jobName string, unlabelled bool, page, jobsPage int) (kb tgbotapi.InlineKeyboardMarkup, e error) {
+	// alerts without job are listed by instance,
+	// or by alertname if instance is not set either
+	if unlabelled {
+		var entries []kbEntry
+		for _, label := range []string{"instance", "alertname"} {
+			filter := append(srv.AlertFilter(), `job=""`)
+			if label == "alertname" {
+				filter = append(filter, `instance=""`)
+			}
+
+			values, err := getAlertLabelValues(ctx, env, label, filter)
 			if err != nil {
-				e, ok := err.(telebot.FloodError)
-				if !ok {
-					break
+				e = fmt.Errorf("error getting alerts: %s", err)
+				return
+			}
+
+			for v := range values {
+				if len(v) == 0 {
+					continue
 				}
 
-				log.Printf("got FloodError, retrying in %d", e.RetryAfter)
-				time.Sleep(time.Second * time.Duration(e.RetryAfter))
-				continue
+				newCallback := Callback{
+					Type: "target",
+					Data: make(map[string]string),
+				}
+				newCallback.Data["env"] = env.Name
+				newCallback.Data["server"] = srv.Name
+				newCallback.Data["unlabelled"] = "yes"
+				newCallback.Data["target_label"] = label
+				newCallback.Data["target_name"] = v
+				newCallback.Data["page"] = strconv.Itoa(page)
+				newCallback.Data["jobs_page"] = strconv.Itoa(jobsPage)
+
+				entries = append(entries, kbEntry{
+					Name:     v,
+					Failing:  true,
+					Callback: newCallback,
+				})
 			}
-			break
 		}
 
+		nav := Callback{
+			Type: "targets",
+			Data: make(map[string]string),
+		}
+		nav.Data["env"] = env.Name
+		nav.Data["server"] = srv.Name
+		nav.Data["unlabelled"] = "yes"
+		nav.Data["jobs_page"] = strconv.Itoa(jobsPage)
+
+		kb, e = newPagedKB(bot, entries, page, nav, nil)
 		return
 	}
 
-	switch m := c.(type) {
-	case tgbotapi.MessageConfig:
-		chunks := splitStringIntoChunks(m.Text)
-		for i, c := range chunks {
-			msg := tgbotapi.NewMessage(m.ChatID, c)
-			msg.ParseMode = m.ParseMode
-			if i == len(chunks)-1 {
-				msg.ReplyMarkup = m.ReplyMarkup
-			}
-			if err = send(msg); err != nil {
-				return
+	targets, err := srv.API.Targets(ctx)
+	if err != nil {
+		e = fmt.Errorf("error getting targets data: %s", err)
+		return
+	}
+
+	// alerting instances of job, including ones that have no prometheus target,
+	// state of other instances is requested for the page shown
+	jobInstances, err := getAlertLabelValues(ctx, env, "instance", append(srv.AlertFilter(), "job="+jobName))
+	if err != nil {
+		e = fmt.Errorf("error getting alerts for job '%s': %s", jobName, err)
+		return
+	}
+
+	instances := make(map[string]bool)
+	for _, t := range targets.Active {
+		if len(t.Labels["job"]) == 0 || len(t.Labels["instance"]) == 0 {
+			b, err := json.Marshal(t)
+			if err != nil {
+				// return fmt.Errorf("could not get labels [job, instance] for target '%v'", t)
+				log.Printf("could not get labels [job, instance] for target '%v'", t)
+				continue
 			}
+			// return fmt.Errorf("could not get labels [job, instance] for target '%s'", string(b))
+			log.Printf("could not get labels [job, instance] for target '%s'", string(b))
+		}
+
+		// target job differs from the one requested in callback, skipping
+		if string(t.Labels["job"]) != jobName {
+			continue
+		}
+
+		instances[string(t.Labels["instance"])] = true
+	}
+	for i := range jobInstances {
+		if len(i) > 0 {
+			instances[i] = true
 		}
+	}
+
+	var entries []kbEntry
+	for i := range instances {
+		newCallback := Callback{
+			Type: "target",
+			Data: make(map[string]string),
+		}
+		newCallback.Data["env"] = env.Name
+		newCallback.Data["server"] = srv.Name
+		newCallback.Data["job_name"] = jobName
+		newCallback.Data["target_label"] = "instance"
+		newCallback.Data["target_name"] = i
+		newCallback.Data["page"] = strconv.Itoa(page)
+		newCallback.Data["jobs_page"] = strconv.Itoa(jobsPage)
+
+		entries = append(entries, kbEntry{
+			Name:     i,
+			Failing:  jobInstances[i] > 0,
+			Callback: newCallback,
+		})
+	}
+
+	nav := Callback{
+		Type: "targets",
+		Data: make(map[string]string),
+	}
+	nav.Data["env"] = env.Name
+	nav.Data["server"] = srv.Name
+	nav.Data["job_name"] = jobName
+	nav.Data["jobs_page"] = strconv.Itoa(jobsPage)
+
+	kb, e = newPagedKB(bot, entries, page, nav, func(names []string) (map[string]bool, error) {
+		return getAlertingValues(ctx, env, "instance", srv.AlertFilter(), names)
+	})
+
+	return
+}
+
+// sendMessage sends reply to user command
+func sendMessage(bot *TelegramBot, c tgbotapi.Chattable) error {
+	return sendMessageWithPriority(bot, c, priorityInteractive, "")
+}
+
+// sendMessageWithPriority sends message in chat worker of scheduler,
+// so chunks of long message are not mixed with other messages
+// pending low priority message is dropped if message with the same group key is sent
+func sendMessageWithPriority(bot *TelegramBot, c tgbotapi.Chattable, priority int, groupKey string) error {
+	return <-queueMessage(bot, c, priority, groupKey)
+}
+
+// queueMessage queues message to send with priority without waiting for it,
+// result is sent to returned channel
+func queueMessage(bot *TelegramBot, c tgbotapi.Chattable, priority int, groupKey string) <-chan error {
+	var chatID int64
+	switch m := c.(type) {
+	case tgbotapi.MessageConfig:
+		chatID = m.ChatID
 	case tgbotapi.EditMessageTextConfig:
-		chunks := splitStringIntoChunks(m.Text)
-		if len(chunks) > 1 {
-			msg := tgbotapi.NewDeleteMessage(m.ChatID, m.MessageID)
-			if err = send(msg); err != nil {
-				return
-			}
+		chatID = m.ChatID
+	case tgbotapi.DeleteMessageConfig:
+		chatID = m.ChatID
+	case tgbotapi.DocumentConfig:
+		chatID = m.ChatID
+	case tgbotapi.EditMessageReplyMarkupConfig:
+		chatID = m.ChatID
+	default:
+		done := make(chan error, 1)
+		done <- fmt.Errorf("unsupported tgbotapi.Chattable type %T", c)
+		return done
+	}
+
+	send := func(m tgbotapi.Chattable) error {
+		return bot.Scheduler.send(chatID, m)
+	}
+
+	return bot.Scheduler.queue(chatID, priority, groupKey, func() (err error) {
+		switch m := c.(type) {
+		case tgbotapi.MessageConfig:
+			chunks := splitMessage(m.Text, m.ParseMode)
 			for i, c := range chunks {
 				msg := tgbotapi.NewMessage(m.ChatID, c)
 				msg.ParseMode = m.ParseMode
+				msg.DisableNotification = m.DisableNotification
 				if i == len(chunks)-1 {
 					msg.ReplyMarkup = m.ReplyMarkup
 				}
@@ -278,22 +733,44 @@ func sendMessage(bot *TelegramBot, c tgbotapi.Chattable) (err error) {
 					return
 				}
 			}
-		} else {
+		case tgbotapi.EditMessageTextConfig:
+			chunks := splitMessage(m.Text, m.ParseMode)
+			if len(chunks) > 1 {
+				msg := tgbotapi.NewDeleteMessage(m.ChatID, m.MessageID)
+				if err = send(msg); err != nil {
+					return
+				}
+				for i, c := range chunks {
+					msg := tgbotapi.NewMessage(m.ChatID, c)
+					msg.ParseMode = m.ParseMode
+					if i == len(chunks)-1 {
+						msg.ReplyMarkup = m.ReplyMarkup
+					}
+					if err = send(msg); err != nil {
+						return
+					}
+				}
+			} else {
+				if err = send(m); err != nil {
+					return
+				}
+			}
+		case tgbotapi.DeleteMessageConfig:
 			if err = send(m); err != nil {
 				return
 			}
+		case tgbotapi.DocumentConfig:
+			if err = send(m); err != nil {
+				return
+			}
+		case tgbotapi.EditMessageReplyMarkupConfig:
+			if err = send(m); err != nil {
+				return
+			}
+		default:
+			return fmt.Errorf("unsupported tgbotapi.Chattable type %T", c)
 		}
-	case tgbotapi.DeleteMessageConfig:
-		if err = send(m); err != nil {
-			return
-		}
-	case tgbotapi.EditMessageReplyMarkupConfig:
-		if err = send(m); err != nil {
-			return
-		}
-	default:
-		return fmt.Errorf("unsupported tgbotapi.Chattable type %T", c)
-	}
 
-	return
+		return
+	})
 }
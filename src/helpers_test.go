@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/ReneKroon/ttlcache/v2"
+	"github.com/prometheus/alertmanager/pkg/labels"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+func TestNewPagedKB(t *testing.T) {
+	names := func(names ...string) (entries []kbEntry) {
+		for _, n := range names {
+			entries = append(entries, kbEntry{Name: n, Callback: Callback{Type: "job", Data: map[string]string{"job_name": n}}})
+		}
+		return
+	}
+
+	tests := []struct {
+		name         string
+		entries      []kbEntry
+		page         int
+		pageSize     int
+		failingFirst bool
+		want         [][]string
+		// pages of navigation buttons by label
+		wantPages map[string]string
+	}{
+		{
+			name:     "single page sorted",
+			entries:  names("b", "C", "a"),
+			pageSize: 20,
+			want:     [][]string{{"a", "b"}, {"C"}},
+		},
+		{
+			name:     "paging disabled",
+			entries:  names("a", "b", "c", "d", "e"),
+			pageSize: 0,
+			want:     [][]string{{"a", "b"}, {"c", "d"}, {"e"}},
+		},
+		{
+			name: "failing first",
+			entries: []kbEntry{
+				{Name: "a"},
+				{Name: "b", Failing: true},
+				{Name: "c"},
+			},
+			pageSize:     20,
+			failingFirst: true,
+			want:         [][]string{{"-b", "+a"}, {"+c"}},
+		},
+		{
+			name:      "first page",
+			entries:   names("a1", "b1", "a2", "c1", "b2"),
+			pageSize:  2,
+			want:      [][]string{{"a1", "a2"}, {"Next »"}, {"A", "B", "C"}},
+			wantPages: map[string]string{"Next »": "1", "A": "0", "B": "1", "C": "2"},
+		},
+		{
+			name:      "middle page",
+			entries:   names("a1", "b1", "a2", "c1", "b2"),
+			page:      1,
+			pageSize:  2,
+			want:      [][]string{{"b1", "b2"}, {"« Prev", "Next »"}, {"A", "B", "C"}},
+			wantPages: map[string]string{"« Prev": "0", "Next »": "2"},
+		},
+		{
+			name:      "page out of range is the last one",
+			entries:   names("a1", "b1", "a2", "c1", "b2"),
+			page:      10,
+			pageSize:  2,
+			want:      [][]string{{"c1"}, {"« Prev"}, {"A", "B", "C"}},
+			wantPages: map[string]string{"« Prev": "1"},
+		},
+		{
+			name:     "no letters for one letter",
+			entries:  names("a1", "a2", "a3"),
+			pageSize: 2,
+			want:     [][]string{{"a1", "a2"}, {"Next »"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, func(c *Config) {
+				c.KeyboardRows = 2
+				c.KeyboardPageSize = tt.pageSize
+				c.KeyboardFailingFirst = tt.failingFirst
+				if tt.failingFirst {
+					c.ButtonPrefixOK, c.ButtonPrefixFail = "+", "-"
+				}
+			})
+
+			cache := ttlcache.NewCache()
+			defer cache.Close()
+			bot := &TelegramBot{Cache: cache}
+
+			nav := Callback{Type: "jobs", Data: map[string]string{"server": "srv"}}
+			kb, err := newPagedKB(bot, tt.entries, tt.page, nav, nil)
+			if err != nil {
+				t.Fatalf("newPagedKB() error = %s", err)
+			}
+
+			var got [][]string
+			for _, row := range kb.InlineKeyboard {
+				var labels []string
+				for _, btn := range row {
+					labels = append(labels, btn.Text)
+					if page, ok := tt.wantPages[btn.Text]; ok {
+						checkNavButton(t, bot, btn, page)
+					}
+				}
+				got = append(got, labels)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got keyboard %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func checkNavButton(t *testing.T, bot *TelegramBot, btn tgbotapi.InlineKeyboardButton, page string) {
+	t.Helper()
+
+	v, err := bot.Cache.Get(*btn.CallbackData)
+	if err != nil {
+		t.Errorf("callback of '%s' button not found: %s", btn.Text, err)
+		return
+	}
+	cb := v.(Callback)
+	if cb.Type != "jobs" || cb.Data["server"] != "srv" || cb.Data["page"] != page {
+		t.Errorf("'%s' button callback %v, want jobs callback of server srv, page %s", btn.Text, cb, page)
+	}
+}
+
+func TestNewPagedKBFailing(t *testing.T) {
+	entries := func() (entries []kbEntry) {
+		for _, n := range []string{"a1", "a2", "b1", "b2", "c1"} {
+			entries = append(entries, kbEntry{Name: n})
+		}
+		// known to be failing, e.g. job without target
+		entries = append(entries, kbEntry{Name: "c2", Failing: true})
+		return
+	}
+	alerting := map[string]bool{"a2": true, "b1": true}
+
+	tests := []struct {
+		name         string
+		page         int
+		failingFirst bool
+		want         [][]string
+		// names state is requested for
+		wantRequested []string
+		// pages of letter buttons
+		wantPages map[string]string
+	}{
+		{
+			name:          "state of page only",
+			page:          1,
+			want:          [][]string{{"+b1", "-b2"}, {"« Prev", "Next »"}, {"A", "B", "C"}},
+			wantRequested: []string{"b1", "b2"},
+			wantPages:     map[string]string{"A": "0", "B": "1", "C": "2"},
+		},
+		{
+			name:          "known failing entries not requested",
+			page:          2,
+			want:          [][]string{{"-c1", "+c2"}, {"« Prev"}, {"A", "B", "C"}},
+			wantRequested: []string{"c1"},
+		},
+		{
+			name:          "failing first requests every entry",
+			failingFirst:  true,
+			want:          [][]string{{"+a2", "+b1"}, {"Next »"}, {"A", "B", "C"}},
+			wantRequested: []string{"a1", "a2", "b1", "b2", "c1"},
+			// letters point to alphabetical part after failing entries
+			wantPages: map[string]string{"A": "1", "B": "2", "C": "2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, func(c *Config) {
+				c.KeyboardRows = 2
+				c.KeyboardPageSize = 2
+				c.KeyboardFailingFirst = tt.failingFirst
+				c.ButtonPrefixOK, c.ButtonPrefixFail = "-", "+"
+			})
+
+			cache := ttlcache.NewCache()
+			defer cache.Close()
+			bot := &TelegramBot{Cache: cache}
+
+			var requested []string
+			failing := func(names []string) (map[string]bool, error) {
+				requested = append(requested, names...)
+				return alerting, nil
+			}
+
+			nav := Callback{Type: "jobs", Data: map[string]string{"server": "srv"}}
+			kb, err := newPagedKB(bot, entries(), tt.page, nav, failing)
+			if err != nil {
+				t.Fatalf("newPagedKB() error = %s", err)
+			}
+
+			var got [][]string
+			for _, row := range kb.InlineKeyboard {
+				var labels []string
+				for _, btn := range row {
+					labels = append(labels, btn.Text)
+					if page, ok := tt.wantPages[btn.Text]; ok {
+						checkNavButton(t, bot, btn, page)
+					}
+				}
+				got = append(got, labels)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got keyboard %q, want %q", got, tt.want)
+			}
+
+			sort.Strings(requested)
+			if !reflect.DeepEqual(requested, tt.wantRequested) {
+				t.Errorf("got state requested for %v, want %v", requested, tt.wantRequested)
+			}
+		})
+	}
+}
+
+func TestLabelFilter(t *testing.T) {
+	values := []string{"node", "a.b", `c"d`, `e\f`, "g|h"}
+
+	m, err := labels.ParseMatcher(labelFilter("job", "=~", values))
+	if err != nil {
+		t.Fatalf("error parsing filter: %s", err)
+	}
+	for _, v := range values {
+		if !m.Matches(v) {
+			t.Errorf("filter %s doesn't match %s", m, v)
+		}
+	}
+	for _, v := range []string{"nodes", "axb", "g", ""} {
+		if m.Matches(v) {
+			t.Errorf("filter %s matches %s", m, v)
+		}
+	}
+}
+
+// kbLabels returns labels of keyboard buttons by rows
+func kbLabels(kb tgbotapi.InlineKeyboardMarkup) (labels [][]string) {
+	for _, row := range kb.InlineKeyboard {
+		var r []string
+		for _, btn := range row {
+			r = append(r, btn.Text)
+		}
+		labels = append(labels, r)
+	}
+	return
+}
+
+func TestNewJobsKB(t *testing.T) {
+	setTestConfig(t, func(c *Config) {
+		c.KeyboardRows = 2
+		c.KeyboardPageSize = 20
+		c.ButtonPrefixOK, c.ButtonPrefixFail = "+", "-"
+	})
+
+	prom := newTestPrometheus(t,
+		map[string]string{"job": "node", "instance": "node1:9100"},
+		map[string]string{"job": "prometheus", "instance": "localhost:9090"},
+	)
+	am := newTestAlertmanager(t,
+		map[string]string{"alertname": "NodeDown", "job": "node", "instance": "node1:9100"},
+		// alert-only job without prometheus target
+		map[string]string{"alertname": "ProbeFailed", "job": "blackbox", "instance": "https://example.com"},
+		map[string]string{"alertname": "Watchdog"},
+	)
+	env := newTestEnvironment(t, am.URL, prom.URL)
+
+	cache := ttlcache.NewCache()
+	defer cache.Close()
+	bot := &TelegramBot{Cache: cache}
+
+	kb, err := newJobsKB(context.Background(), bot, env, env.Prometheus[0], 0)
+	if err != nil {
+		t.Fatalf("newJobsKB() error = %s", err)
+	}
+
+	want := [][]string{{"-blackbox", "-node"}, {"+prometheus"}, {"-" + unlabelledJobName}, {"Close menu"}}
+	if got := kbLabels(kb); !reflect.DeepEqual(got, want) {
+		t.Errorf("got keyboard %q, want %q", got, want)
+	}
+}
+
+func TestNewTargetsKB(t *testing.T) {
+	setTestConfig(t, func(c *Config) {
+		c.KeyboardRows = 2
+		c.KeyboardPageSize = 20
+		c.ButtonPrefixOK, c.ButtonPrefixFail = "+", "-"
+	})
+
+	prom := newTestPrometheus(t,
+		map[string]string{"job": "node", "instance": "node1:9100"},
+		map[string]string{"job": "node", "instance": "node2:9100"},
+	)
+	am := newTestAlertmanager(t,
+		map[string]string{"alertname": "NodeDown", "job": "node", "instance": "node1:9100"},
+		map[string]string{"alertname": "ProbeFailed", "job": "blackbox", "instance": "https://example.com"},
+		map[string]string{"alertname": "Watchdog"},
+		map[string]string{"alertname": "HostDown", "instance": "host1"},
+	)
+	env := newTestEnvironment(t, am.URL, prom.URL)
+
+	tests := []struct {
+		name       string
+		job        string
+		unlabelled bool
+		want       [][]string
+	}{
+		{
+			name: "job with targets",
+			job:  "node",
+			want: [][]string{{"-node1:9100", "+node2:9100"}},
+		},
+		{
+			name: "alert-only job",
+			job:  "blackbox",
+			want: [][]string{{"-https://example.com"}},
+		},
+		{
+			name:       "alerts without job",
+			unlabelled: true,
+			want:       [][]string{{"-host1", "-Watchdog"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := ttlcache.NewCache()
+			defer cache.Close()
+			bot := &TelegramBot{Cache: cache}
+
+			kb, err := newTargetsKB(context.Background(), bot, env, env.Prometheus[0], tt.job, tt.unlabelled, 0, 0)
+			if err != nil {
+				t.Fatalf("newTargetsKB() error = %s", err)
+			}
+			if got := kbLabels(kb); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got keyboard %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"fmt"
 	"log"
 	"strconv"
+	"strings"
+	"time"
 
 	alerttmpl "github.com/prometheus/alertmanager/template"
-	"github.com/segmentio/ksuid"
 	"github.com/valyala/fasthttp"
 	tgbotapi "gopkg.in/telegram-bot-api.v4"
 )
@@ -14,8 +18,10 @@ import (
 func handleHTTP(ctx *fasthttp.RequestCtx, bot *TelegramBot) {
 	log.Printf("new http connection from %s", ctx.RemoteAddr())
 
-	switch ctxPath := string(ctx.Path()); ctxPath {
-	case "/alerts":
+	ctxPath := string(ctx.Path())
+	switch {
+	// tenant could be set with path, e.g. /alerts/<TENANT>
+	case ctxPath == "/alerts" || strings.HasPrefix(ctxPath, "/alerts/"):
 		// only POST supported
 		if !ctx.IsPost() {
 			log.Printf("wrong http method %s", ctx.Method())
@@ -39,48 +45,202 @@ func handleHTTP(ctx *fasthttp.RequestCtx, bot *TelegramBot) {
 			return
 		}
 
+		var tenant *TenantConfig
+		if tenantName := strings.TrimPrefix(strings.TrimPrefix(ctxPath, "/alerts"), "/"); len(tenantName) > 0 {
+			if tenant = getTenant(tenantName); tenant == nil {
+				log.Printf("unknown tenant '%s'", tenantName)
+				return
+			}
+		}
+
+		// environment could be set with ?env=<NAME>
+		// defaults to the first configured one
+		env := cfg().environments[0]
+		if envName := string(ctx.QueryArgs().Peek("env")); len(envName) > 0 {
+			if env = bot.getEnvironment(envName); env == nil {
+				log.Printf("unknown environment '%s'", envName)
+				return
+			}
+		}
+
+		// alertmanager could be set with ?alertmanager=<NAME>
+		// otherwise it's detected by webhook external url
+		// silence button is not shown for unknown alertmanager
+		amName := string(ctx.QueryArgs().Peek("alertmanager"))
+		am := env.webhookAlertmanager(amName, data.ExternalURL)
+		if am == nil {
+			log.Printf("alertmanager of webhook not found by name '%s' or external url '%s' in environment '%s'", amName, data.ExternalURL, env.Name)
+		}
+
+		// route could be set with ?route=<NAME>
+		// otherwise the first route matching chat and group labels is used
+		route := webhookRoute(chatID, data)
+		if routeName := string(ctx.QueryArgs().Peek("route")); len(routeName) > 0 {
+			if route = getRoute(routeName); route == nil {
+				log.Printf("unknown route '%s'", routeName)
+				return
+			}
+		}
+
 		// send plain json if no template defined in config
-		if len(cfg.WebhookAlertsTemplatePath) == 0 {
+		if len(env.WebhookAlertsTemplatePath) == 0 {
 			msg = tgbotapi.NewMessage(chatID, string(ctx.PostBody()))
 		} else {
-			s, err := applyTemplate(data, cfg.WebhookAlertsTemplatePath)
+			queryTenant := tenant
+			if queryTenant == nil {
+				queryTenant = defaultTenant()
+			}
+			queryCtx := withTenant(context.Background(), queryTenant)
+
+			s, err := applyTemplate(data, env.WebhookAlertsTemplatePath, externalURLFunc(data.ExternalURL), queryFuncs(queryCtx, env), ackFuncs(bot), oncallFuncs(bot))
 			if err != nil {
 				log.Println(err)
 				return
 			}
 
-			msg = tgbotapi.NewMessage(chatID, s)
+			msg = tgbotapi.NewMessage(chatID, environmentHeader(bot, env)+tenantOutputHeader(tenant)+alertmanagerHeader(env, am)+s+oncallMention(bot, route, data))
 			msg.ParseMode = tgbotapi.ModeHTML
 		}
 
-		// add silence buttons
-		// we want silence alerts by matching instance and alertname
-		// so alertmanager grouping must be configured
-		//     group_by: ['instance','alertname'])
-		//
-		// if neither 'instance' nor 'alertname' is found in alert.GroupLabels.Names()
-		// the button will not be visible
-		if data.Status == "firing" && len(data.GroupLabels["instance"]) > 0 && len(data.GroupLabels["alertname"]) > 0 {
-			// create new cache entry
-			cacheID := ksuid.New().String()
-			newCallback := Callback{
-				Type: "silence",
-				Data: make(map[string]string),
+		// updates of acked group keep ack banner
+		// ack is cleared when all group alerts are resolved
+		groupKey := alertGroupKey(env, tenant, am, data)
+		text := msg.Text
+		ack := bot.State.GetAck(groupKey)
+		if ack != nil {
+			if data.Status == "resolved" {
+				if err := bot.State.RemoveAck(groupKey); err != nil {
+					log.Printf("error removing ack: %s", err)
+				}
+			} else {
+				ack.Fingerprints = firingFingerprints(data)
+				if err := bot.State.SetAck(groupKey, *ack); err != nil {
+					log.Printf("error saving ack: %s", err)
+				}
+				msg.Text = ackBanner(ack, msg.ParseMode) + "\n" + text
 			}
-			newCallback.Data["instance"] = data.GroupLabels["instance"]
-			newCallback.Data["alertname"] = data.GroupLabels["alertname"]
-			bot.Cache.Set(cacheID, newCallback)
+		}
+
+		// unacked firing group is escalated by matching policy until acked, silenced or resolved
+		// held and digest webhooks are escalated as well, as nobody could ack them meanwhile
+		updateEscalation(bot, groupKey, chatID, env, am, tenant, data, text, msg.ParseMode)
 
-			row := tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Silence", cacheID))
-			kb := tgbotapi.NewInlineKeyboardMarkup(row)
+		// buttons of firing group refer to its state by group id
+		// group is kept for held and digest webhooks too, so their escalations have buttons
+		// silence button is not visible for unknown alertmanager
+		// or if neither 'instance' nor 'alertname' is found in group labels
+		id := groupID(groupKey)
+		if data.Status == "firing" {
+			g := AlertGroup{
+				Key:          groupKey,
+				Env:          env.Name,
+				GroupLabels:  data.GroupLabels,
+				Fingerprints: firingFingerprints(data),
+				Text:         text,
+				ParseMode:    msg.ParseMode,
+				Updated:      time.Now(),
+			}
+			if am != nil {
+				g.Alertmanager = am.Name
+			}
+			if tenant != nil {
+				g.Tenant = tenant.Name
+			}
+			if err := bot.State.SetAlertGroup(id, g); err != nil {
+				log.Printf("error saving alert group: %s", err)
+			}
 
+			kb := groupKeyboard(&g, ack != nil)
 			msg.ReplyMarkup = &kb
+		} else if err := bot.State.RemoveAlertGroup(id); err != nil {
+			log.Printf("error removing alert group: %s", err)
 		}
 
-		if e := sendMessage(bot, msg); e != nil {
-			log.Printf("error sending message: %s", e)
+		// webhooks are held during quiet hours unless severity bypasses them
+		// and sent as one summary when quiet hours end
+		// subscribers get them right away, as quiet hours and digests are set for chat
+		var silent bool
+		if route != nil && route.quiet != nil && !route.quiet.bypasses(data) {
+			if now := time.Now(); route.quiet.isQuiet(now) {
+				if route.quiet.Mode == quietModeHold {
+					key := digestKey{route: route.Name, chatID: chatID, quiet: true}
+					bot.Digests.add(bot, key, env, data, route.quiet.end(now).Sub(now), route.quiet.TemplatePath)
+					go notifySubscribers(bot, env, tenant, chatID, data, msg.Text, msg.ParseMode, alertsPriority(data.Alerts), groupKey)
+					return
+				}
+				silent = true
+			}
+		}
+
+		// webhooks of digest route are sent later as one message
+		if route != nil && route.Digest != nil {
+			key := digestKey{route: route.Name, chatID: chatID}
+			bot.Digests.add(bot, key, env, data, route.Digest.Interval, route.Digest.TemplatePath)
+			go notifySubscribers(bot, env, tenant, chatID, data, msg.Text, msg.ParseMode, alertsPriority(data.Alerts), groupKey)
+			return
+		}
+
+		msg.DisableNotification = silent
+
+		// critical alerts are sent first during alert storm
+		// webhook response doesn't wait for chat rate limits
+		done := queueMessage(bot, msg, alertsPriority(data.Alerts), groupKey)
+		go func() {
+			err := <-done
+			if err == errMessageSuperseded {
+				log.Printf("message for group %s superseded by a newer one", groupKey)
+			} else if err != nil {
+				log.Printf("error sending message: %s", err)
+			}
+
+			// subscribed users get the message in private chat after the group chat
+			notifySubscribers(bot, env, tenant, chatID, data, msg.Text, msg.ParseMode, alertsPriority(data.Alerts), groupKey)
+		}()
+	case ctxPath == "/-/reload":
+		// only POST supported
+		if !ctx.IsPost() {
+			log.Printf("wrong http method %s", ctx.Method())
+			ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+			return
+		}
+
+		// endpoint is disabled unless reload token is set
+		token := cfg().ReloadToken
+		if len(token) == 0 || subtle.ConstantTimeCompare(ctx.Request.Header.Peek("Authorization"), []byte("Bearer "+token)) != 1 {
+			log.Printf("unauthorized reload request")
+			ctx.SetStatusCode(fasthttp.StatusForbidden)
+			return
+		}
+
+		if err := reloadConfig(bot); err != nil {
+			ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+			ctx.SetBodyString(err.Error())
 		}
 	default:
 		log.Printf("wrong path %s", ctxPath)
 	}
 }
+
+// alertGroupKey returns key of alert group unique across environments, tenants and alertmanagers
+// unknown alertmanager is identified by webhook external url
+func alertGroupKey(env *Environment, tenant *TenantConfig, am *Alertmanager, data alerttmpl.Data) string {
+	key := env.Name + "/"
+	if tenant != nil {
+		key += tenant.Name
+	}
+	if am != nil {
+		key += "/" + am.Name
+	} else {
+		key += "/" + data.ExternalURL
+	}
+	return key + "/" + webhookGroupKey(data)
+}
+
+// webhookGroupKey returns key of alertmanager alert group webhook is sent for
+func webhookGroupKey(data alerttmpl.Data) string {
+	var pairs []string
+	for _, p := range data.GroupLabels.SortedPairs() {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", p.Name, p.Value))
+	}
+	return data.Receiver + ":{" + strings.Join(pairs, ",") + "}"
+}
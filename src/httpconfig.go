@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// HTTPClientConfig is http client configuration of api endpoint,
+// mirrors prometheus http_config
+type HTTPClientConfig struct {
+	BasicAuth       *BasicAuth        `yaml:"basic_auth"`
+	BearerToken     string            `yaml:"bearer_token"`
+	BearerTokenFile string            `yaml:"bearer_token_file"`
+	TLSConfig       TLSConfig         `yaml:"tls_config"`
+	ProxyURL        string            `yaml:"proxy_url"`
+	Headers         map[string]string `yaml:"headers"`
+}
+
+type BasicAuth struct {
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+type TLSConfig struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+func (c HTTPClientConfig) validate() error {
+	if len(c.BearerToken) > 0 && len(c.BearerTokenFile) > 0 {
+		return fmt.Errorf("at most one of bearer_token & bearer_token_file must be configured")
+	}
+	if c.BasicAuth != nil && (len(c.BearerToken) > 0 || len(c.BearerTokenFile) > 0) {
+		return fmt.Errorf("at most one of basic_auth, bearer_token & bearer_token_file must be configured")
+	}
+	if c.BasicAuth != nil && len(c.BasicAuth.Password) > 0 && len(c.BasicAuth.PasswordFile) > 0 {
+		return fmt.Errorf("at most one of basic_auth password & password_file must be configured")
+	}
+	if (len(c.TLSConfig.CertFile) > 0) != (len(c.TLSConfig.KeyFile) > 0) {
+		return fmt.Errorf("both tls_config cert_file & key_file must be configured")
+	}
+	return nil
+}
+
+func newTLSConfig(c TLSConfig) (*tls.Config, error) {
+	tlsConfig := tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if len(c.CAFile) > 0 {
+		b, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %s", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in CA file '%s'", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(c.CertFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &tlsConfig, nil
+}
+
+// newRoundTripper creates http transport for api clients from config
+func newRoundTripper(c HTTPClientConfig) (http.RoundTripper, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := newTLSConfig(c.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	if len(c.ProxyURL) > 0 {
+		proxyURL, err := url.Parse(c.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing proxy_url: %s", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &authRoundTripper{
+		config: c,
+		next:   transport,
+	}, nil
+}
+
+// authRoundTripper sets authorization and custom headers on every request
+// secret files are read on each request, so they could be rotated without restart
+type authRoundTripper struct {
+	config HTTPClientConfig
+	next   http.RoundTripper
+}
+
+func (rt *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+
+	for k, v := range rt.config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	// tenant of multi-tenant alertmanager / prometheus (Mimir, Cortex)
+	if t := tenantFromContext(req.Context()); t != nil {
+		req.Header.Set(tenantHeader, t.OrgID)
+	}
+
+	switch {
+	case rt.config.BasicAuth != nil:
+		password := rt.config.BasicAuth.Password
+		if len(rt.config.BasicAuth.PasswordFile) > 0 {
+			b, err := ioutil.ReadFile(rt.config.BasicAuth.PasswordFile)
+			if err != nil {
+				return nil, fmt.Errorf("error reading basic auth password file: %s", err)
+			}
+			password = strings.TrimSpace(string(b))
+		}
+		req.SetBasicAuth(rt.config.BasicAuth.Username, password)
+	case len(rt.config.BearerTokenFile) > 0:
+		b, err := ioutil.ReadFile(rt.config.BearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading bearer token file: %s", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(b)))
+	case len(rt.config.BearerToken) > 0:
+		req.Header.Set("Authorization", "Bearer "+rt.config.BearerToken)
+	}
+
+	return rt.next.RoundTrip(req)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestHTTPClientConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  HTTPClientConfig
+		wantErr bool
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:   "basic auth",
+			config: HTTPClientConfig{BasicAuth: &BasicAuth{Username: "user", Password: "pass"}},
+		},
+		{
+			name:    "bearer token and file",
+			config:  HTTPClientConfig{BearerToken: "token", BearerTokenFile: "token_file"},
+			wantErr: true,
+		},
+		{
+			name:    "basic auth and bearer token",
+			config:  HTTPClientConfig{BasicAuth: &BasicAuth{Username: "user"}, BearerToken: "token"},
+			wantErr: true,
+		},
+		{
+			name:    "password and password file",
+			config:  HTTPClientConfig{BasicAuth: &BasicAuth{Username: "user", Password: "pass", PasswordFile: "pass_file"}},
+			wantErr: true,
+		},
+		{
+			name:    "cert without key",
+			config:  HTTPClientConfig{TLSConfig: TLSConfig{CertFile: "cert.pem"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewRoundTripperErrors(t *testing.T) {
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatalf("error writing CA file: %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		config HTTPClientConfig
+	}{
+		{
+			name:   "invalid config",
+			config: HTTPClientConfig{BearerToken: "token", BearerTokenFile: "token_file"},
+		},
+		{
+			name:   "missing CA file",
+			config: HTTPClientConfig{TLSConfig: TLSConfig{CAFile: filepath.Join(dir, "missing.pem")}},
+		},
+		{
+			name:   "no certificates in CA file",
+			config: HTTPClientConfig{TLSConfig: TLSConfig{CAFile: caFile}},
+		},
+		{
+			name:   "invalid proxy url",
+			config: HTTPClientConfig{ProxyURL: "http://proxy:port"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newRoundTripper(tt.config); err == nil {
+				t.Errorf("newRoundTripper() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestAuthRoundTripper(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	writeSecret := func(name, s string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(s), 0600); err != nil {
+			t.Fatalf("error writing secret file: %s", err)
+		}
+		return path
+	}
+	tokenFile := writeSecret("token", "file-token\n")
+	passwordFile := writeSecret("password", "file-pass\n")
+
+	tests := []struct {
+		name       string
+		config     HTTPClientConfig
+		wantAuth   string
+		wantHeader map[string]string
+	}{
+		{
+			name: "no auth",
+		},
+		{
+			name:     "basic auth",
+			config:   HTTPClientConfig{BasicAuth: &BasicAuth{Username: "user", Password: "pass"}},
+			wantAuth: "Basic dXNlcjpwYXNz",
+		},
+		{
+			name:     "basic auth password file",
+			config:   HTTPClientConfig{BasicAuth: &BasicAuth{Username: "user", PasswordFile: passwordFile}},
+			wantAuth: "Basic dXNlcjpmaWxlLXBhc3M=",
+		},
+		{
+			name:     "bearer token",
+			config:   HTTPClientConfig{BearerToken: "token"},
+			wantAuth: "Bearer token",
+		},
+		{
+			name:     "bearer token file",
+			config:   HTTPClientConfig{BearerTokenFile: tokenFile},
+			wantAuth: "Bearer file-token",
+		},
+		{
+			name:       "custom headers",
+			config:     HTTPClientConfig{Headers: map[string]string{"X-Custom": "value"}},
+			wantHeader: map[string]string{"X-Custom": "value"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt, err := newRoundTripper(tt.config)
+			if err != nil {
+				t.Fatalf("newRoundTripper() error = %s", err)
+			}
+
+			resp, err := (&http.Client{Transport: rt}).Get(srv.URL)
+			if err != nil {
+				t.Fatalf("request error: %s", err)
+			}
+			resp.Body.Close()
+
+			if a := got.Get("Authorization"); a != tt.wantAuth {
+				t.Errorf("got Authorization %q, want %q", a, tt.wantAuth)
+			}
+			for k, v := range tt.wantHeader {
+				if h := got.Get(k); h != v {
+					t.Errorf("got %s %q, want %q", k, h, v)
+				}
+			}
+		})
+	}
+
+	// secret files are read on each request
+	rt, err := newRoundTripper(HTTPClientConfig{BearerTokenFile: tokenFile})
+	if err != nil {
+		t.Fatalf("newRoundTripper() error = %s", err)
+	}
+	writeSecret("token", "rotated-token")
+	resp, err := (&http.Client{Transport: rt}).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("request error: %s", err)
+	}
+	resp.Body.Close()
+	if a := got.Get("Authorization"); a != "Bearer rotated-token" {
+		t.Errorf("got Authorization %q after token rotation, want %q", a, "Bearer rotated-token")
+	}
+}
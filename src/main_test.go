@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ReneKroon/ttlcache/v2"
+	"github.com/kelseyhightower/envconfig"
+	"github.com/prometheus/alertmanager/pkg/labels"
+	"github.com/prometheus/common/model"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// setTestConfig stores config with default values changed by f as current one
+func setTestConfig(t *testing.T, f func(c *Config)) {
+	t.Helper()
+
+	var c Config
+	if err := envconfig.Process("", &c); err != nil {
+		t.Fatalf("error processing env vars: %s", err)
+	}
+	if f != nil {
+		f(&c)
+	}
+	config.Store(&c)
+}
+
+// newTestAlertmanager starts alertmanager api serving active alerts with given labels,
+// alerts are filtered by matchers of request like alertmanager does
+func newTestAlertmanager(t *testing.T, alerts ...map[string]string) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/alerts" {
+			http.NotFound(w, r)
+			return
+		}
+
+		var matchers labels.Matchers
+		for _, f := range r.URL.Query()["filter"] {
+			m, err := labels.ParseMatcher(f)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			matchers = append(matchers, m)
+		}
+
+		result := []map[string]interface{}{}
+		for _, a := range alerts {
+			ls := make(model.LabelSet)
+			for k, v := range a {
+				ls[model.LabelName(k)] = model.LabelValue(v)
+			}
+			if !matchers.Matches(ls) {
+				continue
+			}
+
+			result = append(result, map[string]interface{}{
+				"labels":      a,
+				"annotations": map[string]string{},
+				"fingerprint": ls.Fingerprint().String(),
+				"receivers":   []map[string]string{{"name": "telegram"}},
+				"startsAt":    "2021-01-01T00:00:00.000Z",
+				"endsAt":      "2021-01-01T01:00:00.000Z",
+				"updatedAt":   "2021-01-01T00:00:00.000Z",
+				"status": map[string]interface{}{
+					"state":       "active",
+					"silencedBy":  []string{},
+					"inhibitedBy": []string{},
+				},
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// newTestPrometheus starts prometheus api serving active targets with given labels
+// and their job label values
+func newTestPrometheus(t *testing.T, targets ...map[string]string) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data interface{}
+		switch r.URL.Path {
+		case "/api/v1/label/job/values":
+			jobs := []string{}
+			seen := make(map[string]bool)
+			for _, t := range targets {
+				if !seen[t["job"]] {
+					seen[t["job"]] = true
+					jobs = append(jobs, t["job"])
+				}
+			}
+			data = jobs
+		case "/api/v1/targets":
+			active := []map[string]interface{}{}
+			for _, t := range targets {
+				active = append(active, map[string]interface{}{
+					"labels":           t,
+					"discoveredLabels": map[string]string{},
+					"scrapePool":       t["job"],
+					"scrapeUrl":        "http://" + t["instance"] + "/metrics",
+					"lastScrape":       "2021-01-01T00:00:00Z",
+					"health":           "up",
+				})
+			}
+			data = map[string]interface{}{
+				"activeTargets":  active,
+				"droppedTargets": []interface{}{},
+			}
+		case "/api/v1/status/buildinfo":
+			data = map[string]string{"version": "2.30.0"}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+			"data":   data,
+		})
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// newTestEnvironment returns environment with the default alertmanager and prometheus at given urls
+func newTestEnvironment(t *testing.T, alertmanagerURL, prometheusURL string) *Environment {
+	t.Helper()
+
+	alertmanagers, err := newAlertmanagers(nil, alertmanagerURL, HTTPClientConfig{})
+	if err != nil {
+		t.Fatalf("error creating alertmanagers: %s", err)
+	}
+	servers, err := newPrometheusServers(nil, prometheusURL, HTTPClientConfig{})
+	if err != nil {
+		t.Fatalf("error creating prometheus servers: %s", err)
+	}
+
+	return &Environment{
+		Name:          "default",
+		Alertmanagers: alertmanagers,
+		Prometheus:    servers,
+	}
+}
+
+// testTelegram is telegram bot api recording requests sent by bot
+type testTelegram struct {
+	mtx      sync.Mutex
+	requests []testTelegramRequest
+}
+
+// testTelegramRequest is bot api method call with its params and uploaded file
+type testTelegramRequest struct {
+	Method string
+	Params url.Values
+	File   string
+}
+
+func (tg *testTelegram) RoundTrip(r *http.Request) (*http.Response, error) {
+	req := testTelegramRequest{
+		Method: path.Base(r.URL.Path),
+	}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			return nil, err
+		}
+		req.Params = r.MultipartForm.Value
+		for _, files := range r.MultipartForm.File {
+			f, err := files[0].Open()
+			if err != nil {
+				return nil, err
+			}
+			b, err := ioutil.ReadAll(f)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+			req.File = string(b)
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+		req.Params = r.PostForm
+	}
+
+	tg.mtx.Lock()
+	tg.requests = append(tg.requests, req)
+	messageID := len(tg.requests)
+	tg.mtx.Unlock()
+
+	result := "true"
+	switch req.Method {
+	case "sendMessage", "sendDocument", "editMessageText":
+		result = fmt.Sprintf(`{"message_id": %d, "date": %d, "chat": {"id": %s}}`, messageID, time.Now().Unix(), req.Params.Get("chat_id"))
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewBufferString(`{"ok": true, "result": ` + result + `}`)),
+		Request:    r,
+	}, nil
+}
+
+// sent returns requests sent by bot so far
+func (tg *testTelegram) sent() []testTelegramRequest {
+	tg.mtx.Lock()
+	defer tg.mtx.Unlock()
+	return append([]testTelegramRequest(nil), tg.requests...)
+}
+
+// newTestBot returns bot with in-memory state sending requests to testTelegram
+func newTestBot(t *testing.T) (*TelegramBot, *testTelegram) {
+	t.Helper()
+
+	state, err := loadState("")
+	if err != nil {
+		t.Fatalf("error loading state: %s", err)
+	}
+
+	cache := ttlcache.NewCache()
+	t.Cleanup(func() { cache.Close() })
+
+	tg := &testTelegram{}
+	api := &tgbotapi.BotAPI{
+		Token:  "test",
+		Client: &http.Client{Transport: tg},
+		Self:   tgbotapi.User{ID: 1, UserName: "test_bot"},
+	}
+
+	return &TelegramBot{
+		BotAPI:    api,
+		Scheduler: newScheduler(api),
+		Digests:   newDigests(),
+		State:     state,
+		Cache:     cache,
+		StartTime: time.Now(),
+	}, tg
+}
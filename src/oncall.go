@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+	"time"
+
+	alerttmpl "github.com/prometheus/alertmanager/template"
+)
+
+// layout of override start & end in config
+const overrideTimeLayout = "2006-01-02 15:04"
+
+// RotationConfig is on-call rotation from config file
+// members take shifts of length in turn, the first shift starts on start date at handoff time
+type RotationConfig struct {
+	Name      string           `yaml:"name"`
+	Members   []string         `yaml:"members"`
+	TimeZone  string           `yaml:"time_zone"`
+	Start     string           `yaml:"start"`
+	Handoff   string           `yaml:"handoff"`
+	Length    time.Duration    `yaml:"length"`
+	Overrides []OverrideConfig `yaml:"overrides"`
+}
+
+// OverrideConfig is a planned swap, user is on call from start till end instead of rotation member
+type OverrideConfig struct {
+	User  string `yaml:"user"`
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+}
+
+// OncallOverride is a swap of on-call rotation, overrides set with /oncall are persisted in state
+type OncallOverride struct {
+	Rotation string    `json:"rotation"`
+	User     string    `json:"user"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	By       string    `json:"by,omitempty"`
+}
+
+// Rotation is parsed on-call rotation
+type Rotation struct {
+	RotationConfig
+	location  *time.Location
+	start     time.Time
+	overrides []OncallOverride
+}
+
+// RouteOncallConfig enables mention of on-call user in route webhook messages
+// with firing alerts of severities (any severity if not set)
+type RouteOncallConfig struct {
+	Rotation   string   `yaml:"rotation"`
+	Severities []string `yaml:"severities"`
+}
+
+// usernameRegexp matches telegram username with optional leading @
+var usernameRegexp = regexp.MustCompile(`^@?[A-Za-z0-9_]{5,32}$`)
+
+// normalizeUser strips leading @ from username
+func normalizeUser(s string) string {
+	return strings.TrimPrefix(strings.TrimSpace(s), "@")
+}
+
+// isOncallUser returns true if s could be put on call in rotation,
+// either it's a valid telegram username or one of rotation members
+func (r *Rotation) isOncallUser(s string) bool {
+	if usernameRegexp.MatchString(s) {
+		return true
+	}
+	for _, m := range r.Members {
+		if m == normalizeUser(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func newRotations(configs []RotationConfig, defaultTimeZone string) (rotations []*Rotation, err error) {
+	names := make(map[string]bool)
+	for _, c := range configs {
+		if len(c.Name) == 0 {
+			return nil, fmt.Errorf("rotation name is not set")
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("duplicate rotation name '%s'", c.Name)
+		}
+		names[c.Name] = true
+
+		if len(c.Members) == 0 {
+			return nil, fmt.Errorf("rotation '%s': no members set", c.Name)
+		}
+		for i := range c.Members {
+			c.Members[i] = normalizeUser(c.Members[i])
+		}
+
+		// weekly rotation by default
+		if c.Length == 0 {
+			c.Length = 7 * 24 * time.Hour
+		}
+		if c.Length < 0 {
+			return nil, fmt.Errorf("rotation '%s': length must be positive", c.Name)
+		}
+		if len(c.Handoff) == 0 {
+			c.Handoff = "09:00"
+		}
+
+		r := Rotation{
+			RotationConfig: c,
+		}
+
+		tz := c.TimeZone
+		if len(tz) == 0 {
+			tz = defaultTimeZone
+		}
+		if r.location, err = time.LoadLocation(tz); err != nil {
+			return nil, fmt.Errorf("rotation '%s': error loading timezone '%s': %s", c.Name, tz, err)
+		}
+
+		if _, err := parseTimeOfDay(c.Handoff); err != nil {
+			return nil, fmt.Errorf("rotation '%s': %s", c.Name, err)
+		}
+		if r.start, err = time.ParseInLocation(overrideTimeLayout, c.Start+" "+c.Handoff, r.location); err != nil {
+			return nil, fmt.Errorf("rotation '%s': error parsing start date '%s', must be in YYYY-MM-DD format", c.Name, c.Start)
+		}
+
+		for _, o := range c.Overrides {
+			override := OncallOverride{
+				Rotation: c.Name,
+				User:     normalizeUser(o.User),
+			}
+			if override.Start, err = time.ParseInLocation(overrideTimeLayout, o.Start, r.location); err != nil {
+				return nil, fmt.Errorf("rotation '%s': error parsing override start '%s': %s", c.Name, o.Start, err)
+			}
+			if override.End, err = time.ParseInLocation(overrideTimeLayout, o.End, r.location); err != nil {
+				return nil, fmt.Errorf("rotation '%s': error parsing override end '%s': %s", c.Name, o.End, err)
+			}
+			r.overrides = append(r.overrides, override)
+		}
+
+		rotations = append(rotations, &r)
+	}
+
+	return
+}
+
+// getRotation returns rotation by name or nil if not found
+func getRotation(name string) *Rotation {
+	for _, r := range cfg().rotations {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// shiftStart returns start of k-th shift
+// shifts of whole days start at handoff time regardless of DST
+func (r *Rotation) shiftStart(k int) time.Time {
+	if r.Length%(24*time.Hour) == 0 {
+		return r.start.AddDate(0, 0, k*int(r.Length/(24*time.Hour)))
+	}
+	return r.start.Add(time.Duration(k) * r.Length)
+}
+
+// shift returns number of shift t is within
+func (r *Rotation) shift(t time.Time) int {
+	k := int(t.Sub(r.start) / r.Length)
+	for r.shiftStart(k).After(t) {
+		k--
+	}
+	for !r.shiftStart(k + 1).After(t) {
+		k++
+	}
+	return k
+}
+
+// oncall returns user on call at t and time the duty ends
+// overrides take precedence over shifts, the latest added override wins
+func (r *Rotation) oncall(t time.Time, overrides []OncallOverride) (user string, until time.Time, override *OncallOverride) {
+	all := append(append([]OncallOverride{}, r.overrides...), overrides...)
+	for i := len(all) - 1; i >= 0; i-- {
+		if o := all[i]; !t.Before(o.Start) && t.Before(o.End) {
+			return o.User, o.End, &o
+		}
+	}
+
+	k := r.shift(t)
+	n := len(r.Members)
+	return r.Members[(k%n+n)%n], r.shiftStart(k + 1), nil
+}
+
+// oncallFunc returns template function returning mention of user currently on call,
+// in the first rotation if name is not passed
+// overrides from state are taken into account if it's set
+//
+//	{{ oncall "primary" }}
+func oncallFunc(s *State) func(name ...string) (string, error) {
+	return func(name ...string) (string, error) {
+		var r *Rotation
+		switch {
+		case len(name) > 0:
+			if r = getRotation(name[0]); r == nil {
+				return "", fmt.Errorf("unknown rotation '%s'", name[0])
+			}
+		case len(cfg().rotations) > 0:
+			r = cfg().rotations[0]
+		default:
+			return "", nil
+		}
+
+		var overrides []OncallOverride
+		if s != nil {
+			overrides = s.GetOncallOverrides(r.Name)
+		}
+		user, _, _ := r.oncall(time.Now(), overrides)
+		return "@" + user, nil
+	}
+}
+
+// oncallFuncs returns template functions for on-call rotations with overrides from state
+func oncallFuncs(bot *TelegramBot) template.FuncMap {
+	return template.FuncMap{
+		"oncall": oncallFunc(bot.State),
+	}
+}
+
+// oncallMention returns line mentioning on-call user of route
+// if webhook has firing alerts of route oncall severities
+func oncallMention(bot *TelegramBot, route *Route, data alerttmpl.Data) string {
+	if route == nil || route.Oncall == nil {
+		return ""
+	}
+
+	matched := len(route.Oncall.Severities) == 0 && len(data.Alerts.Firing()) > 0
+	for _, a := range data.Alerts.Firing() {
+		for _, s := range route.Oncall.Severities {
+			if a.Labels["severity"] == s {
+				matched = true
+			}
+		}
+	}
+	if !matched {
+		return ""
+	}
+
+	mention, err := oncallFunc(bot.State)(route.Oncall.Rotation)
+	if err != nil || len(mention) == 0 {
+		return ""
+	}
+	return "\n📟 On call: " + html.EscapeString(mention)
+}
+
+// oncallStatus returns current and next on-call users of rotations for /oncall
+func oncallStatus(bot *TelegramBot) string {
+	if len(cfg().rotations) == 0 {
+		return "No on-call rotations configured."
+	}
+
+	var b strings.Builder
+	now := time.Now()
+	for _, r := range cfg().rotations {
+		overrides := bot.State.GetOncallOverrides(r.Name)
+		user, until, override := r.oncall(now, overrides)
+		next, _, _ := r.oncall(until, overrides)
+
+		fmt.Fprintf(&b, "<b>%s</b>\n", html.EscapeString(r.Name))
+		fmt.Fprintf(&b, "Now: @%s until <b>%s</b>", html.EscapeString(user), until.In(r.location).Format(cfg().TimeFormat))
+		if override != nil {
+			b.WriteString(" (override")
+			if len(override.By) > 0 {
+				fmt.Fprintf(&b, " by %s", html.EscapeString(override.By))
+			}
+			b.WriteString(")")
+		}
+		fmt.Fprintf(&b, "\nNext: @%s\n\n", html.EscapeString(next))
+	}
+
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	alerttmpl "github.com/prometheus/alertmanager/template"
+)
+
+func testRotation(t *testing.T, c RotationConfig) *Rotation {
+	t.Helper()
+
+	c.Name = "primary"
+	c.Members = []string{"@alice", "bob", "carol"}
+	c.TimeZone = "Europe/Berlin"
+	c.Start = "2026-10-19"
+	rotations, err := newRotations([]RotationConfig{c}, "UTC")
+	if err != nil {
+		t.Fatalf("error creating rotation: %s", err)
+	}
+	return rotations[0]
+}
+
+func parseTestTime(t *testing.T, s string) time.Time {
+	t.Helper()
+
+	tm, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t.Fatalf("error parsing time: %s", err)
+	}
+	return tm
+}
+
+func TestRotationShift(t *testing.T) {
+	weekly := RotationConfig{}
+	halfDay := RotationConfig{Length: 12 * time.Hour, Handoff: "08:00"}
+
+	tests := []struct {
+		name     string
+		rotation RotationConfig
+		time     string
+		want     int
+	}{
+		{"rotation start", weekly, "2026-10-19T09:00:00+02:00", 0},
+		{"before rotation start", weekly, "2026-10-19T08:59:00+02:00", -1},
+		{"the first shift end", weekly, "2026-10-26T08:59:00+01:00", 0},
+		{"the second shift start after dst change", weekly, "2026-10-26T09:00:00+01:00", 1},
+		{"many shifts later", weekly, "2027-04-05T09:00:00+02:00", 24},
+		{"shifts before start", weekly, "2026-10-12T08:59:00+02:00", -2},
+		{"half day shift", halfDay, "2026-10-19T20:00:00+02:00", 1},
+		{"half day shifts are not aligned to handoff after dst change", halfDay, "2026-10-26T07:00:00+01:00", 14},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := testRotation(t, tt.rotation)
+			if got := r.shift(parseTestTime(t, tt.time)); got != tt.want {
+				t.Errorf("shift(%s) = %d, want %d", tt.time, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRotationOncall(t *testing.T) {
+	withOverride := RotationConfig{Overrides: []OverrideConfig{{User: "@dave", Start: "2026-10-20 12:00", End: "2026-10-21 12:00"}}}
+	stateOverrides := []OncallOverride{{
+		Rotation: "primary",
+		User:     "erin",
+		Start:    parseTestTime(t, "2026-10-21T00:00:00+02:00"),
+		End:      parseTestTime(t, "2026-10-22T00:00:00+02:00"),
+	}}
+
+	tests := []struct {
+		name      string
+		rotation  RotationConfig
+		overrides []OncallOverride
+		time      string
+		wantUser  string
+		wantUntil string
+		// override is expected to be returned
+		wantOverride bool
+	}{
+		{"the first member", RotationConfig{}, nil, "2026-10-19T09:00:00+02:00", "alice", "2026-10-26T09:00:00+01:00", false},
+		{"the next member", RotationConfig{}, nil, "2026-10-27T09:00:00+01:00", "bob", "2026-11-02T09:00:00+01:00", false},
+		{"members in turn", RotationConfig{}, nil, "2026-11-09T09:00:00+01:00", "alice", "2026-11-16T09:00:00+01:00", false},
+		{"before rotation start", RotationConfig{}, nil, "2026-10-19T08:00:00+02:00", "carol", "2026-10-19T09:00:00+02:00", false},
+		{"config override", withOverride, nil, "2026-10-20T12:00:00+02:00", "dave", "2026-10-21T12:00:00+02:00", true},
+		{"config override end is excluded", withOverride, nil, "2026-10-21T12:00:00+02:00", "alice", "2026-10-26T09:00:00+01:00", false},
+		{"state override wins", withOverride, stateOverrides, "2026-10-21T06:00:00+02:00", "erin", "2026-10-22T00:00:00+02:00", true},
+		{"config override outside of state one", withOverride, stateOverrides, "2026-10-20T23:00:00+02:00", "dave", "2026-10-21T12:00:00+02:00", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := testRotation(t, tt.rotation)
+			user, until, override := r.oncall(parseTestTime(t, tt.time), tt.overrides)
+			if user != tt.wantUser {
+				t.Errorf("got user %s, want %s", user, tt.wantUser)
+			}
+			if want := parseTestTime(t, tt.wantUntil); !until.Equal(want) {
+				t.Errorf("got until %s, want %s", until, want)
+			}
+			if (override != nil) != tt.wantOverride {
+				t.Errorf("got override %v, want override %t", override, tt.wantOverride)
+			}
+		})
+	}
+}
+
+func TestRotationIsOncallUser(t *testing.T) {
+	r := testRotation(t, RotationConfig{})
+
+	tests := []struct {
+		user string
+		want bool
+	}{
+		{"@frank_1", true},
+		{"frank_1", true},
+		{"@bob", true},
+		{"bob", true},
+		{"@eve", false},
+		{"<bob>", false},
+		{"@frank<b>", false},
+		{"@" + strings.Repeat("a", 33), false},
+	}
+
+	for _, tt := range tests {
+		if got := r.isOncallUser(tt.user); got != tt.want {
+			t.Errorf("isOncallUser(%s) = %t, want %t", tt.user, got, tt.want)
+		}
+	}
+}
+
+func TestOncallEscaped(t *testing.T) {
+	rotations, err := newRotations([]RotationConfig{{
+		Name:    "<primary>",
+		Members: []string{"<bob>"},
+		Start:   "2026-10-19",
+	}}, "UTC")
+	if err != nil {
+		t.Fatalf("error creating rotation: %s", err)
+	}
+	setTestConfig(t, func(c *Config) {
+		c.rotations = rotations
+	})
+
+	state, err := loadState("")
+	if err != nil {
+		t.Fatalf("error loading state: %s", err)
+	}
+	bot := &TelegramBot{State: state}
+
+	status := oncallStatus(bot)
+	if strings.Contains(status, "<bob>") || strings.Contains(status, "<primary>") {
+		t.Errorf("oncallStatus() is not escaped: %s", status)
+	}
+
+	route := &Route{RouteConfig: RouteConfig{Oncall: &RouteOncallConfig{Rotation: "<primary>"}}}
+	data := alerttmpl.Data{Alerts: alerttmpl.Alerts{{Status: "firing"}}}
+	if got, want := oncallMention(bot, route, data), "\n📟 On call: @&lt;bob&gt;"; got != want {
+		t.Errorf("oncallMention() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// PrometheusConfig is a named prometheus endpoint from config file
+type PrometheusConfig struct {
+	Name           string            `yaml:"name"`
+	URL            string            `yaml:"url"`
+	ExternalLabels map[string]string `yaml:"external_labels"`
+	HTTPConfig     HTTPClientConfig  `yaml:"http_config"`
+}
+
+// PrometheusServer is a named prometheus api client
+// alerts are correlated to the server by its external labels
+type PrometheusServer struct {
+	apiHealth
+
+	Name           string
+	API            v1.API
+	ExternalLabels map[string]string
+}
+
+// AlertFilter returns alertmanager filter for alerts originating from this server
+func (p *PrometheusServer) AlertFilter() (filter []string) {
+	for k, v := range p.ExternalLabels {
+		filter = append(filter, fmt.Sprintf("%s=%q", k, v))
+	}
+	sort.Strings(filter)
+	return
+}
+
+// newPrometheusServers creates prometheus clients from configs
+// or a single one from defaultURL if configs are not set
+func newPrometheusServers(configs []PrometheusConfig, defaultURL string, defaultHTTPConfig HTTPClientConfig) (servers []*PrometheusServer, err error) {
+	if len(configs) == 0 {
+		configs = []PrometheusConfig{
+			{
+				Name:       "default",
+				URL:        defaultURL,
+				HTTPConfig: defaultHTTPConfig,
+			},
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, c := range configs {
+		if len(c.Name) == 0 {
+			return nil, fmt.Errorf("prometheus server name is not set for '%s'", c.URL)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("duplicate prometheus server name '%s'", c.Name)
+		}
+		names[c.Name] = true
+
+		rt, err := newRoundTripper(c.HTTPConfig)
+		if err != nil {
+			return nil, fmt.Errorf("error creating prometheus '%s' http client: %s", c.Name, err)
+		}
+
+		promCli, err := api.NewClient(api.Config{
+			Address:      c.URL,
+			RoundTripper: rt,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error creating prometheus client '%s': %s", c.Name, err)
+		}
+
+		servers = append(servers, &PrometheusServer{
+			Name:           c.Name,
+			API:            v1.NewAPI(promCli),
+			ExternalLabels: c.ExternalLabels,
+		})
+	}
+
+	return
+}
+
+// checkHealth requests prometheus build info to check its availability
+func (p *PrometheusServer) checkHealth(ctx context.Context) {
+	_, err := p.API.Buildinfo(ctx)
+	if p.update(err) {
+		if err != nil {
+			log.Printf("prometheus '%s' is unreachable: %s", p.Name, err)
+		} else {
+			log.Printf("prometheus '%s' is reachable again", p.Name)
+		}
+	}
+}
+
+// getPrometheus returns prometheus server by name or nil if not found
+func (env *Environment) getPrometheus(name string) *PrometheusServer {
+	for _, p := range env.Prometheus {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
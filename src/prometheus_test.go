@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/ReneKroon/ttlcache/v2"
+)
+
+func TestNewPrometheusServers(t *testing.T) {
+	tests := []struct {
+		name      string
+		configs   []PrometheusConfig
+		wantNames []string
+		wantErr   bool
+	}{
+		{
+			name:      "default server",
+			wantNames: []string{"default"},
+		},
+		{
+			name: "named servers",
+			configs: []PrometheusConfig{
+				{Name: "dc1", URL: "http://prometheus-dc1:9090"},
+				{Name: "dc2", URL: "http://prometheus-dc2:9090"},
+			},
+			wantNames: []string{"dc1", "dc2"},
+		},
+		{
+			name:    "name not set",
+			configs: []PrometheusConfig{{URL: "http://prometheus:9090"}},
+			wantErr: true,
+		},
+		{
+			name: "duplicate name",
+			configs: []PrometheusConfig{
+				{Name: "dc1", URL: "http://prometheus-dc1:9090"},
+				{Name: "dc1", URL: "http://prometheus-dc2:9090"},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			servers, err := newPrometheusServers(tt.configs, "http://localhost:9090", HTTPClientConfig{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newPrometheusServers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var names []string
+			for _, s := range servers {
+				names = append(names, s.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("got servers %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestAlertFilter(t *testing.T) {
+	srv := PrometheusServer{
+		ExternalLabels: map[string]string{"region": "eu", "dc": `dc"1`},
+	}
+
+	want := []string{`dc="dc\"1"`, `region="eu"`}
+	if got := srv.AlertFilter(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got filter %q, want %q", got, want)
+	}
+
+	if got := (&PrometheusServer{}).AlertFilter(); len(got) != 0 {
+		t.Errorf("got filter %q of server without external labels, want empty", got)
+	}
+}
+
+func TestNewServersKB(t *testing.T) {
+	setTestConfig(t, func(c *Config) {
+		c.KeyboardRows = 2
+		c.KeyboardPageSize = 20
+		c.ButtonPrefixOK, c.ButtonPrefixFail = "+", "-"
+	})
+
+	am := newTestAlertmanager(t,
+		map[string]string{"alertname": "NodeDown", "dc": "dc2"},
+	)
+	alertmanagers, err := newAlertmanagers(nil, am.URL, HTTPClientConfig{})
+	if err != nil {
+		t.Fatalf("error creating alertmanagers: %s", err)
+	}
+	servers, err := newPrometheusServers([]PrometheusConfig{
+		{Name: "dc1", URL: "http://prometheus-dc1:9090", ExternalLabels: map[string]string{"dc": "dc1"}},
+		{Name: "dc2", URL: "http://prometheus-dc2:9090", ExternalLabels: map[string]string{"dc": "dc2"}},
+	}, "", HTTPClientConfig{})
+	if err != nil {
+		t.Fatalf("error creating prometheus servers: %s", err)
+	}
+	env := &Environment{
+		Name:          "default",
+		Alertmanagers: alertmanagers,
+		Prometheus:    servers,
+	}
+
+	cache := ttlcache.NewCache()
+	defer cache.Close()
+	bot := &TelegramBot{Cache: cache}
+
+	kb, err := newServersKB(context.Background(), bot, env, 0)
+	if err != nil {
+		t.Fatalf("newServersKB() error = %s", err)
+	}
+
+	want := [][]string{{"+dc1", "-dc2"}, {"Close menu"}}
+	if got := kbLabels(kb); !reflect.DeepEqual(got, want) {
+		t.Errorf("got keyboard %q, want %q", got, want)
+	}
+
+	if p := env.getPrometheus("dc2"); p == nil || p.Name != "dc2" {
+		t.Errorf("getPrometheus(dc2) = %v, want server dc2", p)
+	}
+	if p := env.getPrometheus("dc3"); p != nil {
+		t.Errorf("getPrometheus(dc3) = %v, want nil", p)
+	}
+}
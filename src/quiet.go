@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	alerttmpl "github.com/prometheus/alertmanager/template"
+)
+
+const (
+	// webhooks are held during quiet hours and sent as summary when they end
+	quietModeHold = "hold"
+	// webhooks are sent without notification during quiet hours
+	quietModeSilent = "silent"
+)
+
+// QuietHoursConfig is quiet hours of route
+// time is quiet if it's within any of intervals
+type QuietHoursConfig struct {
+	TimeZone         string          `yaml:"time_zone"`
+	Intervals        []QuietInterval `yaml:"intervals"`
+	Mode             string          `yaml:"mode"`
+	BypassSeverities []string        `yaml:"bypass_severities"`
+	TemplatePath     string          `yaml:"template_path"`
+}
+
+// QuietInterval is a set of time ranges on weekdays,
+// all day if times are not set, every day if weekdays are not set
+type QuietInterval struct {
+	Times    []TimeRange `yaml:"times"`
+	Weekdays []string    `yaml:"weekdays"`
+}
+
+// TimeRange is a time of day range, e.g. 22:00 - 08:00
+type TimeRange struct {
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+}
+
+// quietHours is parsed quiet hours config
+type quietHours struct {
+	QuietHoursConfig
+	location  *time.Location
+	intervals []quietInterval
+}
+
+type quietInterval struct {
+	// minutes of day
+	ranges   [][2]int
+	weekdays map[time.Weekday]bool
+}
+
+func parseTimeOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing time '%s', must be in HH:MM format", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(d.String(), s) {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown weekday '%s'", s)
+}
+
+func newQuietHours(c QuietHoursConfig, defaultTimeZone string) (*quietHours, error) {
+	q := quietHours{
+		QuietHoursConfig: c,
+	}
+
+	switch q.Mode {
+	case "":
+		q.Mode = quietModeHold
+	case quietModeHold, quietModeSilent:
+	default:
+		return nil, fmt.Errorf("unknown quiet hours mode '%s'", q.Mode)
+	}
+
+	if len(c.Intervals) == 0 {
+		return nil, fmt.Errorf("no quiet hours intervals set")
+	}
+
+	tz := c.TimeZone
+	if len(tz) == 0 {
+		tz = defaultTimeZone
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("error loading quiet hours timezone '%s': %s", tz, err)
+	}
+	q.location = loc
+
+	for _, i := range c.Intervals {
+		var qi quietInterval
+		for _, r := range i.Times {
+			start, err := parseTimeOfDay(r.Start)
+			if err != nil {
+				return nil, err
+			}
+			end, err := parseTimeOfDay(r.End)
+			if err != nil {
+				return nil, err
+			}
+			// empty range is never quiet, most likely all day was meant
+			if start == end {
+				return nil, fmt.Errorf("quiet hours range %s - %s is empty, omit times for all day", r.Start, r.End)
+			}
+			qi.ranges = append(qi.ranges, [2]int{start, end})
+		}
+
+		if len(i.Weekdays) > 0 {
+			qi.weekdays = make(map[time.Weekday]bool)
+			for _, s := range i.Weekdays {
+				d, err := parseWeekday(s)
+				if err != nil {
+					return nil, err
+				}
+				qi.weekdays[d] = true
+			}
+		}
+
+		q.intervals = append(q.intervals, qi)
+	}
+
+	return &q, nil
+}
+
+// contains checks if time is within interval
+// range ending before its start (e.g. 22:00 - 08:00) lasts till next day,
+// it's weekday is the one it starts on
+func (i quietInterval) contains(t time.Time) bool {
+	if len(i.ranges) == 0 {
+		return i.weekdays == nil || i.weekdays[t.Weekday()]
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	for _, r := range i.ranges {
+		start, end := r[0], r[1]
+		switch {
+		case start <= end:
+			if minute >= start && minute < end && (i.weekdays == nil || i.weekdays[t.Weekday()]) {
+				return true
+			}
+		case minute >= start:
+			if i.weekdays == nil || i.weekdays[t.Weekday()] {
+				return true
+			}
+		case minute < end:
+			if i.weekdays == nil || i.weekdays[t.AddDate(0, 0, -1).Weekday()] {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (q *quietHours) isQuiet(t time.Time) bool {
+	t = t.In(q.location)
+	for _, i := range q.intervals {
+		if i.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// end returns time quiet hours started before t end at
+func (q *quietHours) end(t time.Time) time.Time {
+	t = t.Truncate(time.Minute)
+	for i := 0; i < 8*24*60; i++ {
+		t = t.Add(time.Minute)
+		if !q.isQuiet(t) {
+			return t
+		}
+	}
+	return t
+}
+
+// bypasses checks if webhook has firing alerts with severity bypassing quiet hours
+func (q *quietHours) bypasses(data alerttmpl.Data) bool {
+	for _, a := range data.Alerts.Firing() {
+		for _, s := range q.BypassSeverities {
+			if a.Labels["severity"] == s {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuietHoursIsQuiet(t *testing.T) {
+	nights := []QuietInterval{{Times: []TimeRange{{Start: "22:00", End: "08:00"}}}}
+	fridayNights := []QuietInterval{{Times: []TimeRange{{Start: "22:00", End: "08:00"}}, Weekdays: []string{"friday"}}}
+	saturdays := []QuietInterval{{Weekdays: []string{"Saturday"}}}
+
+	tests := []struct {
+		name      string
+		intervals []QuietInterval
+		// time in RFC3339, quiet hours are in Europe/Berlin
+		time string
+		want bool
+	}{
+		{"before midnight", nights, "2026-10-23T23:30:00+02:00", true},
+		{"after midnight", nights, "2026-10-24T07:59:00+02:00", true},
+		{"range end is excluded", nights, "2026-10-24T08:00:00+02:00", false},
+		{"before range start", nights, "2026-10-23T21:59:00+02:00", false},
+		{"range start is included", nights, "2026-10-23T22:00:00+02:00", true},
+
+		{"weekday night", fridayNights, "2026-10-23T23:00:00+02:00", true},
+		{"weekday night after midnight", fridayNights, "2026-10-24T07:00:00+02:00", true},
+		{"previous weekday night", fridayNights, "2026-10-23T07:00:00+02:00", false},
+		{"next weekday night", fridayNights, "2026-10-24T23:00:00+02:00", false},
+
+		{"all day", saturdays, "2026-10-24T12:00:00+02:00", true},
+		{"all day is over at midnight", saturdays, "2026-10-25T00:00:00+02:00", false},
+		{"all day in utc of previous day", saturdays, "2026-10-23T22:30:00Z", true},
+
+		// clocks go forward at 02:00 CET to 03:00 CEST
+		{"before spring dst change", nights, "2026-03-29T01:59:00+01:00", true},
+		{"after spring dst change", nights, "2026-03-29T03:00:00+02:00", true},
+		{"spring dst change morning", nights, "2026-03-29T08:00:00+02:00", false},
+		// clocks go back at 03:00 CEST to 02:00 CET
+		{"before autumn dst change", nights, "2026-10-25T02:30:00+02:00", true},
+		{"after autumn dst change", nights, "2026-10-25T02:30:00+01:00", true},
+		{"autumn dst change morning", nights, "2026-10-25T07:59:00+01:00", true},
+		{"autumn dst change morning end", nights, "2026-10-25T08:00:00+01:00", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := newQuietHours(QuietHoursConfig{TimeZone: "Europe/Berlin", Intervals: tt.intervals}, "UTC")
+			if err != nil {
+				t.Fatalf("error creating quiet hours: %s", err)
+			}
+
+			tm, err := time.Parse(time.RFC3339, tt.time)
+			if err != nil {
+				t.Fatalf("error parsing time: %s", err)
+			}
+
+			if got := q.isQuiet(tm); got != tt.want {
+				t.Errorf("isQuiet(%s) = %t, want %t", tt.time, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuietHoursEnd(t *testing.T) {
+	nights := []QuietInterval{{Times: []TimeRange{{Start: "22:00", End: "08:00"}}}}
+
+	tests := []struct {
+		name string
+		time string
+		want string
+	}{
+		{"same night", "2026-10-23T23:00:00+02:00", "2026-10-24T08:00:00+02:00"},
+		{"spring dst change night is shorter", "2026-03-28T23:00:00+01:00", "2026-03-29T08:00:00+02:00"},
+		{"autumn dst change night is longer", "2026-10-24T23:00:00+02:00", "2026-10-25T08:00:00+01:00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := newQuietHours(QuietHoursConfig{TimeZone: "Europe/Berlin", Intervals: nights}, "UTC")
+			if err != nil {
+				t.Fatalf("error creating quiet hours: %s", err)
+			}
+
+			tm, _ := time.Parse(time.RFC3339, tt.time)
+			want, _ := time.Parse(time.RFC3339, tt.want)
+			if got := q.end(tm); !got.Equal(want) {
+				t.Errorf("end(%s) = %s, want %s", tt.time, got, want)
+			}
+		})
+	}
+}
+
+func TestNewQuietHoursErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		c    QuietHoursConfig
+	}{
+		{"no intervals", QuietHoursConfig{}},
+		{"empty range", QuietHoursConfig{Intervals: []QuietInterval{{Times: []TimeRange{{Start: "08:00", End: "08:00"}}}}}},
+		{"wrong time", QuietHoursConfig{Intervals: []QuietInterval{{Times: []TimeRange{{Start: "8am", End: "20:00"}}}}}},
+		{"wrong weekday", QuietHoursConfig{Intervals: []QuietInterval{{Weekdays: []string{"funday"}}}}},
+		{"wrong mode", QuietHoursConfig{Mode: "mute", Intervals: []QuietInterval{{Weekdays: []string{"sunday"}}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newQuietHours(tt.c, "UTC"); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	// read only access: alerts, silences, targets, queries
+	roleViewer = "viewer"
+	// viewer who could also silence & ack alerts and swap on-call
+	roleOperator = "operator"
+	// operator who could also manage bot
+	roleAdmin = "admin"
+)
+
+var roleLevels = map[string]int{
+	roleViewer:   1,
+	roleOperator: 2,
+	roleAdmin:    3,
+}
+
+// default minimal roles of commands, unknown commands require viewer role
+var commandRoles = map[string]string{
+	"oncall override": roleOperator,
+	"users":           roleAdmin,
+}
+
+// default minimal roles of buttons (callback types), unknown buttons require admin role
+var buttonRoles = map[string]string{
+	"env":         roleViewer,
+	"tenant":      roleViewer,
+	"servers":     roleViewer,
+	"jobs":        roleViewer,
+	"job":         roleViewer,
+	"targets":     roleViewer,
+	"target":      roleViewer,
+	"close":       roleViewer,
+	"unsubscribe": roleViewer,
+	"silence":     roleOperator,
+	"ack":         roleOperator,
+	"unack":       roleOperator,
+	"approve":     roleAdmin,
+	"deny":        roleAdmin,
+	"users":       roleAdmin,
+	"user":        roleAdmin,
+	"set_role":    roleAdmin,
+	"revoke":      roleAdmin,
+}
+
+// RBACConfig assigns roles to users by telegram user id or username
+// minimal roles of commands and buttons could be overridden
+type RBACConfig struct {
+	Viewers   []string          `yaml:"viewers"`
+	Operators []string          `yaml:"operators"`
+	Admins    []string          `yaml:"admins"`
+	Commands  map[string]string `yaml:"commands"`
+	Buttons   map[string]string `yaml:"buttons"`
+}
+
+func validateRBAC(c RBACConfig) error {
+	for _, users := range [][]string{c.Viewers, c.Operators, c.Admins} {
+		if err := validateUsers(users); err != nil {
+			return err
+		}
+	}
+
+	for cmd, role := range c.Commands {
+		if _, ok := roleLevels[role]; !ok {
+			return fmt.Errorf("unknown role '%s' of command '%s'", role, cmd)
+		}
+	}
+	for btn, role := range c.Buttons {
+		if _, ok := roleLevels[role]; !ok {
+			return fmt.Errorf("unknown role '%s' of button '%s'", role, btn)
+		}
+	}
+
+	return nil
+}
+
+// matchesUser checks if users list entry is telegram user id or username of user
+// display name is never matched, as anyone could set it to any value
+func matchesUser(entry string, u *tgbotapi.User) bool {
+	entry = strings.TrimSpace(entry)
+	if id, err := strconv.Atoi(entry); err == nil {
+		return id == u.ID
+	}
+
+	return len(u.UserName) > 0 && strings.EqualFold(normalizeUser(entry), u.UserName)
+}
+
+func matchesAnyUser(entries []string, u *tgbotapi.User) bool {
+	for _, e := range entries {
+		if matchesUser(e, u) {
+			return true
+		}
+	}
+	return false
+}
+
+// userRole returns the highest role of user, empty string if user has no role
+// users of top level 'users' list are operators,
+// roles from config take precedence over roles of users approved by admins
+func (bot *TelegramBot) userRole(u *tgbotapi.User) string {
+	if u == nil {
+		return ""
+	}
+
+	c := cfg().RBAC
+	switch {
+	case matchesAnyUser(c.Admins, u):
+		return roleAdmin
+	case matchesAnyUser(c.Operators, u), matchesAnyUser(cfg().Users, u):
+		return roleOperator
+	case matchesAnyUser(c.Viewers, u):
+		return roleViewer
+	}
+
+	if approved := bot.State.GetApprovedUser(u.ID); approved != nil {
+		return approved.Role
+	}
+
+	return ""
+}
+
+// userChatID returns private chat of user given by telegram user id or username
+// username is resolved through users approved by admins and users who started the bot,
+// the latter must have role in config
+func (bot *TelegramBot) userChatID(user string) (int64, bool) {
+	if id, err := strconv.ParseInt(user, 10, 64); err == nil {
+		return id, true
+	}
+
+	userName := normalizeUser(user)
+	userID, ok := bot.State.GetUserID(userName)
+	for _, u := range bot.State.GetApprovedUsers() {
+		if strings.EqualFold(u.UserName, userName) {
+			userID, ok = u.ID, true
+			break
+		}
+	}
+	if !ok || len(bot.userRole(&tgbotapi.User{ID: userID, UserName: userName})) == 0 {
+		return 0, false
+	}
+
+	// private chat id is the same as user id
+	if chatID, ok := bot.State.GetUserChat(userID); ok {
+		return chatID, true
+	}
+	return int64(userID), true
+}
+
+// hasRole checks if role grants access required
+func hasRole(role, required string) bool {
+	return len(role) > 0 && roleLevels[role] >= roleLevels[required]
+}
+
+// commandRole returns minimal role required for command
+func commandRole(cmd string) string {
+	if role, ok := cfg().RBAC.Commands[cmd]; ok {
+		return role
+	}
+	if role, ok := commandRoles[cmd]; ok {
+		return role
+	}
+	return roleViewer
+}
+
+// buttonRole returns minimal role required to press button of callback type
+func buttonRole(callbackType string) string {
+	if role, ok := cfg().RBAC.Buttons[callbackType]; ok {
+		return role
+	}
+	if role, ok := buttonRoles[callbackType]; ok {
+		return role
+	}
+	return roleAdmin
+}
+
+// authorizeCallback checks user role and access to environment & tenant of callback,
+// unauthorized press is answered with alert, callback stays valid for other users
+func (bot *TelegramBot) authorizeCallback(cq *tgbotapi.CallbackQuery, cb Callback) bool {
+	allowed := hasRole(bot.userRole(cq.From), buttonRole(cb.Type))
+
+	if allowed && len(cb.Data["env"]) > 0 {
+		if env := bot.getEnvironment(cb.Data["env"]); env != nil && !env.isUserAllowed(cq.From) {
+			allowed = false
+		}
+	}
+	if allowed && len(cb.Data["tenant"]) > 0 {
+		if t := getTenant(cb.Data["tenant"]); t != nil && !t.isUserAllowed(cq.From) {
+			allowed = false
+		}
+	}
+	if allowed {
+		return true
+	}
+
+	log.Printf("user %s (%d) is not allowed to press '%s' button", cq.From.String(), cq.From.ID, cb.Type)
+
+	answer := tgbotapi.NewCallbackWithAlert(cq.ID, "You don't have permission to do this.")
+	if _, err := bot.BotAPI.AnswerCallbackQuery(answer); err != nil {
+		log.Printf("error answering callback query: %s", err)
+	}
+
+	return false
+}
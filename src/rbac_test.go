@@ -0,0 +1,235 @@
+package main
+
+import (
+	"testing"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+func TestValidateRBAC(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  RBACConfig
+		wantErr bool
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "valid",
+			config: RBACConfig{
+				Viewers:  []string{"alice"},
+				Admins:   []string{"42"},
+				Commands: map[string]string{"silences": roleOperator},
+				Buttons:  map[string]string{"ack": roleAdmin},
+			},
+		},
+		{
+			name:    "empty user",
+			config:  RBACConfig{Operators: []string{""}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown command role",
+			config:  RBACConfig{Commands: map[string]string{"silences": "root"}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown button role",
+			config:  RBACConfig{Buttons: map[string]string{"ack": "root"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateRBAC(tt.config); (err != nil) != tt.wantErr {
+				t.Errorf("validateRBAC() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMatchesUser(t *testing.T) {
+	tests := []struct {
+		entry string
+		user  *tgbotapi.User
+		want  bool
+	}{
+		{entry: "42", user: &tgbotapi.User{ID: 42}, want: true},
+		{entry: " 42 ", user: &tgbotapi.User{ID: 42}, want: true},
+		{entry: "42", user: &tgbotapi.User{ID: 43, UserName: "42"}},
+		{entry: "alice", user: &tgbotapi.User{ID: 1, UserName: "alice"}, want: true},
+		{entry: "@Alice", user: &tgbotapi.User{ID: 1, UserName: "alice"}, want: true},
+		{entry: "alice", user: &tgbotapi.User{ID: 1}},
+		// display name is never matched
+		{entry: "Alice", user: &tgbotapi.User{ID: 1, FirstName: "Alice"}},
+	}
+
+	for _, tt := range tests {
+		if got := matchesUser(tt.entry, tt.user); got != tt.want {
+			t.Errorf("matchesUser(%q, %+v) = %v, want %v", tt.entry, *tt.user, got, tt.want)
+		}
+	}
+}
+
+func TestUserRole(t *testing.T) {
+	setTestConfig(t, func(c *Config) {
+		c.Users = []string{"olga"}
+		c.RBAC = RBACConfig{
+			Viewers:   []string{"victor", "anna"},
+			Operators: []string{"oscar"},
+			Admins:    []string{"anna", "42"},
+		}
+	})
+	bot, _ := newTestBot(t)
+
+	for _, u := range []ApprovedUser{
+		{ID: 100, UserName: "paul", Role: roleOperator},
+		// config takes precedence
+		{ID: 101, UserName: "victor", Role: roleAdmin},
+	} {
+		if err := bot.State.SetApprovedUser(u); err != nil {
+			t.Fatalf("error saving approved user: %s", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		user *tgbotapi.User
+		want string
+	}{
+		{name: "nil user"},
+		{name: "unknown", user: &tgbotapi.User{ID: 1, UserName: "mallory"}},
+		{name: "viewer", user: &tgbotapi.User{ID: 1, UserName: "victor"}, want: roleViewer},
+		{name: "operator", user: &tgbotapi.User{ID: 1, UserName: "oscar"}, want: roleOperator},
+		{name: "users list is operator", user: &tgbotapi.User{ID: 1, UserName: "olga"}, want: roleOperator},
+		{name: "highest role", user: &tgbotapi.User{ID: 1, UserName: "anna"}, want: roleAdmin},
+		{name: "admin by id", user: &tgbotapi.User{ID: 42}, want: roleAdmin},
+		{name: "approved", user: &tgbotapi.User{ID: 100, UserName: "paul"}, want: roleOperator},
+		{name: "config over approved", user: &tgbotapi.User{ID: 101, UserName: "victor"}, want: roleViewer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bot.userRole(tt.user); got != tt.want {
+				t.Errorf("userRole() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequiredRoles(t *testing.T) {
+	setTestConfig(t, func(c *Config) {
+		c.RBAC = RBACConfig{
+			Commands: map[string]string{"silences": roleOperator, "users": roleOperator},
+			Buttons:  map[string]string{"ack": roleAdmin},
+		}
+	})
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{name: "command default", got: commandRole("alerts"), want: roleViewer},
+		{name: "command builtin", got: commandRole("oncall override"), want: roleOperator},
+		{name: "command overridden", got: commandRole("silences"), want: roleOperator},
+		{name: "builtin command overridden", got: commandRole("users"), want: roleOperator},
+		{name: "button builtin", got: buttonRole("silence"), want: roleOperator},
+		{name: "button overridden", got: buttonRole("ack"), want: roleAdmin},
+		{name: "unknown button", got: buttonRole("new_button"), want: roleAdmin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got role %q, want %q", tt.got, tt.want)
+			}
+		})
+	}
+
+	for _, tt := range []struct {
+		role, required string
+		want           bool
+	}{
+		{role: roleAdmin, required: roleViewer, want: true},
+		{role: roleOperator, required: roleOperator, want: true},
+		{role: roleViewer, required: roleOperator},
+		{role: "", required: roleViewer},
+	} {
+		if got := hasRole(tt.role, tt.required); got != tt.want {
+			t.Errorf("hasRole(%q, %q) = %v, want %v", tt.role, tt.required, got, tt.want)
+		}
+	}
+}
+
+func TestAuthorizeCallback(t *testing.T) {
+	staging := &Environment{Name: "staging", Users: []string{"oscar"}}
+	setTestConfig(t, func(c *Config) {
+		c.RBAC = RBACConfig{
+			Viewers:   []string{"victor"},
+			Operators: []string{"oscar", "otto"},
+		}
+		c.environments = []*Environment{{Name: "prod"}, staging}
+	})
+	bot, tg := newTestBot(t)
+
+	tests := []struct {
+		name string
+		user string
+		cb   Callback
+		want bool
+	}{
+		{
+			name: "allowed",
+			user: "victor",
+			cb:   Callback{Type: "jobs", Data: map[string]string{"env": "prod"}},
+			want: true,
+		},
+		{
+			name: "role too low",
+			user: "victor",
+			cb:   Callback{Type: "ack"},
+		},
+		{
+			name: "allowed in environment",
+			user: "oscar",
+			cb:   Callback{Type: "ack", Data: map[string]string{"env": "staging"}},
+			want: true,
+		},
+		{
+			name: "not allowed in environment",
+			user: "otto",
+			cb:   Callback{Type: "ack", Data: map[string]string{"env": "staging"}},
+		},
+		{
+			name: "no role",
+			user: "mallory",
+			cb:   Callback{Type: "close"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cq := &tgbotapi.CallbackQuery{ID: tt.name, From: &tgbotapi.User{ID: 1, UserName: tt.user}}
+			sent := len(tg.sent())
+
+			if got := bot.authorizeCallback(cq, tt.cb); got != tt.want {
+				t.Errorf("authorizeCallback() = %v, want %v", got, tt.want)
+			}
+
+			// denied press is answered with alert
+			answered := len(tg.sent()) > sent
+			if answered == tt.want {
+				t.Errorf("callback answered = %v, want %v", answered, !tt.want)
+			}
+			if answered {
+				r := tg.sent()[sent]
+				if r.Method != "answerCallbackQuery" || r.Params.Get("callback_query_id") != tt.name || r.Params.Get("show_alert") != "true" {
+					t.Errorf("got request %v, want callback answer with alert", r)
+				}
+			}
+		})
+	}
+}
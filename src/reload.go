@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+var reloadMtx sync.Mutex
+
+// reloadConfig re-reads config file and swaps current config
+// on error the old config stays in use
+// result is reported to log and admin chat
+func reloadConfig(bot *TelegramBot) error {
+	reloadMtx.Lock()
+	defer reloadMtx.Unlock()
+
+	log.Printf("reloading config")
+
+	oldCfg := cfg()
+	newCfg, err := loadConfig()
+	if err != nil {
+		err = fmt.Errorf("error reloading config: %s", err)
+		log.Println(err)
+		notifyAdmin(bot, err.Error())
+		return err
+	}
+
+	for _, s := range nonReloadableChanges(oldCfg, newCfg) {
+		log.Printf("config option '%s' changed, restart is required to apply it", s)
+	}
+
+	config.Store(newCfg)
+
+	log.Printf("config reloaded")
+	notifyAdmin(bot, "config reloaded")
+
+	return nil
+}
+
+// nonReloadableChanges returns options which could not be applied without restart
+func nonReloadableChanges(oldCfg, newCfg *Config) (changed []string) {
+	options := []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"telegram_token", oldCfg.TelegramToken, newCfg.TelegramToken},
+		{"bind_address", oldCfg.BindAddress, newCfg.BindAddress},
+		{"bind_port", oldCfg.BindPort, newCfg.BindPort},
+		{"disable_http", oldCfg.DisableHTTP, newCfg.DisableHTTP},
+		{"logfile_path", oldCfg.LogFile, newCfg.LogFile},
+		{"state_file_path", oldCfg.StateFile, newCfg.StateFile},
+		{"callback_ttl", oldCfg.CallbackTTL, newCfg.CallbackTTL},
+	}
+
+	for _, o := range options {
+		if !reflect.DeepEqual(o.old, o.new) {
+			changed = append(changed, o.name)
+		}
+	}
+
+	return
+}
+
+// notifyAdmin sends message to admin chat if it's configured
+func notifyAdmin(bot *TelegramBot, text string) {
+	if cfg().AdminChatID == 0 {
+		return
+	}
+
+	if err := sendMessage(bot, tgbotapi.NewMessage(cfg().AdminChatID, text)); err != nil {
+		log.Printf("error sending message to admin chat: %s", err)
+	}
+}
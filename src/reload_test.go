@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestReloadConfig(t *testing.T) {
+	oldCLI := cli.ConfigFile
+	defer func() { cli.ConfigFile = oldCLI }()
+
+	cli.ConfigFile = writeTestConfig(t, "config.yaml", "telegram_token: old\nkeyboard_rows: 2\n")
+	c, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig() error = %s", err)
+	}
+	config.Store(c)
+	bot := &TelegramBot{}
+
+	// invalid config keeps the current one
+	if err := os.WriteFile(cli.ConfigFile, []byte("health_check_interval: 0s\n"), 0600); err != nil {
+		t.Fatalf("error writing config file: %s", err)
+	}
+	if err := reloadConfig(bot); err == nil {
+		t.Errorf("reloadConfig() of invalid config succeeded, want error")
+	}
+	if cfg() != c {
+		t.Errorf("config is replaced by invalid one")
+	}
+
+	if err := os.WriteFile(cli.ConfigFile, []byte("telegram_token: old\nkeyboard_rows: 3\n"), 0600); err != nil {
+		t.Fatalf("error writing config file: %s", err)
+	}
+	if err := reloadConfig(bot); err != nil {
+		t.Fatalf("reloadConfig() error = %s", err)
+	}
+	if cfg().KeyboardRows != 3 {
+		t.Errorf("got keyboard_rows %d after reload, want 3", cfg().KeyboardRows)
+	}
+}
+
+func TestNonReloadableChanges(t *testing.T) {
+	oldCfg := Config{TelegramToken: "old", BindPort: 8080, KeyboardRows: 2}
+	newCfg := Config{TelegramToken: "new", BindPort: 8080, KeyboardRows: 3, DisableHTTP: true}
+
+	want := []string{"telegram_token", "disable_http"}
+	if got := nonReloadableChanges(&oldCfg, &newCfg); !reflect.DeepEqual(got, want) {
+		t.Errorf("got changed options %v, want %v", got, want)
+	}
+}
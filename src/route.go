@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/alertmanager/pkg/labels"
+	alerttmpl "github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/common/model"
+)
+
+// RouteConfig is a named webhook route from config file
+// route applies to webhooks sent to its chats and matching its matchers
+type RouteConfig struct {
+	Name       string             `yaml:"name"`
+	Chats      []int64            `yaml:"chats"`
+	Matchers   []string           `yaml:"matchers"`
+	Digest     *DigestConfig      `yaml:"digest"`
+	QuietHours *QuietHoursConfig  `yaml:"quiet_hours"`
+	Oncall     *RouteOncallConfig `yaml:"oncall"`
+}
+
+// DigestConfig enables digest mode of route
+// webhooks are buffered for interval and sent as one message
+type DigestConfig struct {
+	Interval     time.Duration `yaml:"interval"`
+	TemplatePath string        `yaml:"template_path"`
+}
+
+// Route is a webhook route with parsed matchers
+type Route struct {
+	RouteConfig
+	matchers labels.Matchers
+	quiet    *quietHours
+}
+
+func newRoutes(configs []RouteConfig, timeZone string, rotations []*Rotation) (routes []*Route, err error) {
+	names := make(map[string]bool)
+	for _, c := range configs {
+		if len(c.Name) == 0 {
+			return nil, fmt.Errorf("route name is not set")
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("duplicate route name '%s'", c.Name)
+		}
+		names[c.Name] = true
+
+		r := Route{
+			RouteConfig: c,
+		}
+		for _, s := range c.Matchers {
+			m, err := labels.ParseMatcher(s)
+			if err != nil {
+				return nil, fmt.Errorf("route '%s': error parsing matcher '%s': %s", c.Name, s, err)
+			}
+			r.matchers = append(r.matchers, m)
+		}
+
+		if c.Digest != nil && c.Digest.Interval <= 0 {
+			return nil, fmt.Errorf("route '%s': digest interval must be positive", c.Name)
+		}
+
+		if c.QuietHours != nil {
+			if r.quiet, err = newQuietHours(*c.QuietHours, timeZone); err != nil {
+				return nil, fmt.Errorf("route '%s': %s", c.Name, err)
+			}
+		}
+
+		if c.Oncall != nil {
+			found := false
+			for _, rot := range rotations {
+				if rot.Name == c.Oncall.Rotation {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("route '%s': unknown rotation '%s'", c.Name, c.Oncall.Rotation)
+			}
+		}
+
+		routes = append(routes, &r)
+	}
+
+	return
+}
+
+// toLabelSet converts alert labels to prometheus label set for matching
+func toLabelSet(in interface{}) model.LabelSet {
+	lset := make(model.LabelSet)
+	for k, v := range toKV(in) {
+		lset[model.LabelName(k)] = model.LabelValue(v)
+	}
+	return lset
+}
+
+// matches checks if route applies to webhook sent to chat with given group labels
+func (r *Route) matches(chatID int64, kv alerttmpl.KV) bool {
+	if len(r.Chats) > 0 {
+		found := false
+		for _, c := range r.Chats {
+			if c == chatID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return r.matchers.Matches(toLabelSet(kv))
+}
+
+// getRoute returns route by name or nil if not found
+func getRoute(name string) *Route {
+	for _, r := range cfg().routes {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// webhookRoute returns the first route matching webhook or nil if none matched
+func webhookRoute(chatID int64, data alerttmpl.Data) *Route {
+	for _, r := range cfg().routes {
+		if r.matches(chatID, data.CommonLabels) {
+			return r
+		}
+	}
+	return nil
+}
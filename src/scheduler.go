@@ -0,0 +1,365 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"log"
+	"math"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	alerttmpl "github.com/prometheus/alertmanager/template"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// tokenBucket is a rate limiter allowing bursts up to bucket size,
+// rate is passed on every call to pick up config reloads
+type tokenBucket struct {
+	mtx    sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes a token and returns time to wait before it could be used
+func (b *tokenBucket) reserve(rate float64, burst float64) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	now := time.Now()
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+// full checks if bucket is refilled since the last token was taken,
+// so it could be dropped without losing rate limit state
+func (b *tokenBucket) full(rate float64, burst float64) bool {
+	if rate <= 0 {
+		return true
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	return b.last.IsZero() || b.tokens+time.Since(b.last).Seconds()*rate >= burst
+}
+
+// errMessageSuperseded is returned for message dropped from queue
+// because a newer message of the same alert group was queued before it was sent
+var errMessageSuperseded = errors.New("message superseded by a newer one of the same group")
+
+// priority of messages sent in reply to user commands
+const priorityInteractive = math.MaxInt32
+
+// sendJob is a set of telegram requests which must be sent in order
+type sendJob struct {
+	do       func() error
+	done     chan error
+	priority int
+	groupKey string
+	seq      uint64
+}
+
+// jobQueue is a priority queue of send jobs,
+// jobs of the same priority are sent in arrival order
+type jobQueue []*sendJob
+
+func (q jobQueue) Len() int { return len(q) }
+func (q jobQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+func (q jobQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *jobQueue) Push(x interface{}) { *q = append(*q, x.(*sendJob)) }
+func (q *jobQueue) Pop() interface{} {
+	old := *q
+	job := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return job
+}
+
+// globalLimiter grants tokens of global rate limit to chat workers,
+// the highest priority request first, so messages of busy chats
+// don't delay more important messages of other chats
+type globalLimiter struct {
+	bucket tokenBucket
+
+	mtx     sync.Mutex
+	waiting jobQueue
+	seq     uint64
+	running bool
+}
+
+// wait blocks until global token is granted to request of priority
+// waiting requests are queued as jobs without func, done when token is granted
+func (l *globalLimiter) wait(priority int) {
+	job := sendJob{
+		done:     make(chan error, 1),
+		priority: priority,
+	}
+
+	l.mtx.Lock()
+	l.seq++
+	job.seq = l.seq
+	heap.Push(&l.waiting, &job)
+	if !l.running {
+		l.running = true
+		go l.run()
+	}
+	l.mtx.Unlock()
+
+	<-job.done
+}
+
+// run grants tokens one by one, token is given to the highest priority request
+// waiting at the time it's available, runner stops when nobody waits
+func (l *globalLimiter) run() {
+	for {
+		l.mtx.Lock()
+		if len(l.waiting) == 0 {
+			l.running = false
+			l.mtx.Unlock()
+			return
+		}
+		l.mtx.Unlock()
+
+		time.Sleep(l.bucket.reserve(cfg().SendGlobalRate, cfg().SendGlobalRate))
+
+		// requests are only removed here, so queue is not empty
+		l.mtx.Lock()
+		job := heap.Pop(&l.waiting).(*sendJob)
+		l.mtx.Unlock()
+
+		job.done <- nil
+	}
+}
+
+// how often idle chat queues are dropped
+const chatQueuesPruneInterval = time.Minute
+
+// Scheduler sends telegram requests respecting global and per-chat rate limits,
+// requests to one chat are sent one by one by chat worker, higher priority first
+// global rate limit is granted to the highest priority request across chats
+// chat worker is stopped when its queue is empty, idle queues are dropped
+type Scheduler struct {
+	bot    *tgbotapi.BotAPI
+	global globalLimiter
+
+	mtx    sync.Mutex
+	chats  map[int64]*chatQueue
+	seq    uint64
+	pruned time.Time
+}
+
+type chatQueue struct {
+	bucket tokenBucket
+
+	mtx     sync.Mutex
+	jobs    jobQueue
+	running bool
+	// priority of job being sent by worker
+	priority int
+}
+
+func newScheduler(bot *tgbotapi.BotAPI) *Scheduler {
+	return &Scheduler{
+		bot:   bot,
+		chats: make(map[int64]*chatQueue),
+	}
+}
+
+// chatQueue returns queue of chat, must be called with s.mtx held
+func (s *Scheduler) chatQueue(chatID int64) *chatQueue {
+	if time.Since(s.pruned) > chatQueuesPruneInterval {
+		s.prune()
+	}
+
+	q, ok := s.chats[chatID]
+	if !ok {
+		q = &chatQueue{}
+		s.chats[chatID] = q
+	}
+
+	return q
+}
+
+// prune drops queues without jobs and worker, whose rate limit is not in effect anymore
+// must be called with s.mtx held
+func (s *Scheduler) prune() {
+	s.pruned = time.Now()
+
+	for chatID, q := range s.chats {
+		q.mtx.Lock()
+		idle := !q.running && len(q.jobs) == 0
+		q.mtx.Unlock()
+
+		if rate, burst := chatRate(chatID); idle && q.bucket.full(rate, burst) {
+			delete(s.chats, chatID)
+		}
+	}
+}
+
+// run sends queued jobs one by one, worker stops when queue is empty
+func (q *chatQueue) run() {
+	for {
+		q.mtx.Lock()
+		if len(q.jobs) == 0 {
+			q.running = false
+			q.mtx.Unlock()
+			return
+		}
+		job := heap.Pop(&q.jobs).(*sendJob)
+		q.priority = job.priority
+		q.mtx.Unlock()
+
+		job.done <- job.do()
+	}
+}
+
+// push queues job, pending low priority jobs of the same group are dropped
+// worker is started if it's not running
+func (q *chatQueue) push(job *sendJob) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if len(job.groupKey) > 0 {
+		for i := 0; i < len(q.jobs); i++ {
+			old := q.jobs[i]
+			if old.groupKey != job.groupKey || old.priority >= cfg().CoalescePriority {
+				continue
+			}
+			heap.Remove(&q.jobs, i)
+			old.done <- errMessageSuperseded
+			i = -1
+		}
+	}
+
+	heap.Push(&q.jobs, job)
+	if !q.running {
+		q.running = true
+		go q.run()
+	}
+}
+
+// queue queues f to run in chat worker, result is sent to returned channel
+// jobs are queued in call order, so callers which must not wait for rate limits
+// (e.g. http handlers) could wait for the result in goroutine
+func (s *Scheduler) queue(chatID int64, priority int, groupKey string, f func() error) <-chan error {
+	job := sendJob{
+		do:       f,
+		done:     make(chan error, 1),
+		priority: priority,
+		groupKey: groupKey,
+	}
+
+	// queue is not dropped while job is pushed
+	s.mtx.Lock()
+	s.seq++
+	job.seq = s.seq
+	s.chatQueue(chatID).push(&job)
+	s.mtx.Unlock()
+
+	return job.done
+}
+
+// do runs f in chat worker and waits for the result
+func (s *Scheduler) do(chatID int64, priority int, groupKey string, f func() error) error {
+	return <-s.queue(chatID, priority, groupKey, f)
+}
+
+// alertsPriority returns message priority of webhook alerts,
+// the highest priority of firing alerts severities
+// resolved alerts have the lowest priority
+func alertsPriority(alerts alerttmpl.Alerts) (priority int) {
+	for _, a := range alerts.Firing() {
+		if p := cfg().SeverityPriorities[a.Labels["severity"]]; p > priority {
+			priority = p
+		}
+	}
+	return
+}
+
+// chatRate returns rate limit of chat in messages per second
+// telegram allows ~1 msg/s to private chat and ~20 msg/min to group
+func chatRate(chatID int64) (rate float64, burst float64) {
+	if chatID < 0 {
+		return cfg().SendGroupRate / 60, 3
+	}
+	return cfg().SendChatRate, 3
+}
+
+// retryAfterRegexp matches flood wait error of file uploads, which are returned as plain errors
+var retryAfterRegexp = regexp.MustCompile(`[Rr]etry after (\d+)`)
+
+// retryAfter returns flood wait time from telegram error or 0 if error is not flood error
+func retryAfter(err error) time.Duration {
+	if e, ok := err.(tgbotapi.Error); ok && e.RetryAfter > 0 {
+		return time.Duration(e.RetryAfter) * time.Second
+	}
+	if m := retryAfterRegexp.FindStringSubmatch(err.Error()); m != nil {
+		if n, e := strconv.Atoi(m[1]); e == nil {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return 0
+}
+
+// wait waits for chat rate limit and then for global one with priority of job being sent
+// must be called from chat worker
+func (s *Scheduler) wait(chatID int64) {
+	s.mtx.Lock()
+	q := s.chatQueue(chatID)
+	s.mtx.Unlock()
+
+	rate, burst := chatRate(chatID)
+	time.Sleep(q.bucket.reserve(rate, burst))
+
+	q.mtx.Lock()
+	priority := q.priority
+	q.mtx.Unlock()
+
+	s.global.wait(priority)
+}
+
+// send sends telegram request waiting for rate limits, it's retried on flood errors
+// must be called from chat worker
+func (s *Scheduler) send(chatID int64, c tgbotapi.Chattable) (err error) {
+	for i := 0; i < cfg().SendMessageRetryCount; i++ {
+		s.wait(chatID)
+
+		_, err = s.bot.Send(c)
+		if err == nil {
+			return
+		}
+
+		wait := retryAfter(err)
+		if wait == 0 {
+			return
+		}
+
+		log.Printf("telegram flood limit for chat %d, retrying in %s", chatID, wait)
+		time.Sleep(wait)
+	}
+
+	return
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+func TestChatQueuePush(t *testing.T) {
+	type job struct {
+		name     string
+		priority int
+		groupKey string
+	}
+
+	tests := []struct {
+		name string
+		jobs []job
+		// names of jobs in send order
+		want []string
+		// names of jobs dropped as superseded
+		wantSuperseded []string
+	}{
+		{
+			name: "higher priority first",
+			jobs: []job{{"a", 1, ""}, {"b", 3, ""}, {"c", 2, ""}},
+			want: []string{"b", "c", "a"},
+		},
+		{
+			name: "same priority in arrival order",
+			jobs: []job{{"a", 1, ""}, {"b", 1, ""}, {"c", 1, ""}},
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name:           "low priority of the same group superseded",
+			jobs:           []job{{"a", 1, "g"}, {"b", 0, "g"}, {"c", 1, "g"}},
+			want:           []string{"c"},
+			wantSuperseded: []string{"a", "b"},
+		},
+		{
+			name:           "superseded by higher priority",
+			jobs:           []job{{"a", 1, "g"}, {"b", 3, "g"}},
+			want:           []string{"b"},
+			wantSuperseded: []string{"a"},
+		},
+		{
+			name: "coalesce priority not superseded",
+			jobs: []job{{"a", 2, "g"}, {"b", 1, "g"}},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "other groups kept",
+			jobs: []job{{"a", 1, "g1"}, {"b", 1, "g2"}},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "jobs without group never superseded",
+			jobs: []job{{"a", 0, ""}, {"b", 0, ""}},
+			want: []string{"a", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, func(c *Config) {
+				c.CoalescePriority = 2
+			})
+
+			// worker is not started, so jobs stay in queue
+			q := &chatQueue{running: true}
+
+			var pushed []*sendJob
+			names := make(map[*sendJob]string)
+			for i, j := range tt.jobs {
+				job := &sendJob{done: make(chan error, 1), priority: j.priority, groupKey: j.groupKey, seq: uint64(i)}
+				names[job] = j.name
+				pushed = append(pushed, job)
+				q.push(job)
+			}
+
+			var got []string
+			for q.jobs.Len() > 0 {
+				got = append(got, names[heap.Pop(&q.jobs).(*sendJob)])
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got send order %v, want %v", got, tt.want)
+			}
+
+			var superseded []string
+			for _, job := range pushed {
+				select {
+				case err := <-job.done:
+					if err != errMessageSuperseded {
+						t.Errorf("job %s done with %v, want superseded", names[job], err)
+					}
+					superseded = append(superseded, names[job])
+				default:
+				}
+			}
+			if !reflect.DeepEqual(superseded, tt.wantSuperseded) {
+				t.Errorf("got superseded %v, want %v", superseded, tt.wantSuperseded)
+			}
+		})
+	}
+}
+
+func TestSchedulerGlobalPriority(t *testing.T) {
+	setTestConfig(t, func(c *Config) {
+		c.SendGlobalRate = 10
+		c.SendChatRate = 1000
+		c.SendGroupRate = 60000
+	})
+
+	s := newScheduler(nil)
+
+	// global burst is used up, so the next tokens are granted one by one
+	for i := 0; i < 10; i++ {
+		s.global.wait(0)
+	}
+
+	var mtx sync.Mutex
+	var got []string
+	job := func(chatID int64, name string) func() error {
+		return func() error {
+			s.wait(chatID)
+			mtx.Lock()
+			got = append(got, name)
+			mtx.Unlock()
+			return nil
+		}
+	}
+
+	// info messages of chats B and C are waiting for global token before critical one of chat A is queued
+	var done []<-chan error
+	for _, name := range []string{"info1", "info2", "info3"} {
+		done = append(done, s.queue(-2, 0, "", job(-2, name)))
+		done = append(done, s.queue(-3, 0, "", job(-3, name)))
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.global.mtx.Lock()
+		n := len(s.global.waiting)
+		s.global.mtx.Unlock()
+
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d jobs are waiting for global token, want 2", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	done = append(done, s.queue(1, 2, "", job(1, "critical")))
+
+	for _, ch := range done {
+		if err := <-ch; err != nil {
+			t.Fatalf("job error: %s", err)
+		}
+	}
+
+	if len(got) != 7 || got[0] != "critical" {
+		t.Errorf("got send order %v, want critical first", got)
+	}
+}
+
+func TestSchedulerStopsIdleWorkers(t *testing.T) {
+	setTestConfig(t, nil)
+
+	s := newScheduler(nil)
+	for _, chatID := range []int64{1, -2} {
+		var called bool
+		if err := s.do(chatID, 0, "", func() error { called = true; return nil }); err != nil || !called {
+			t.Fatalf("job is not done: %v", err)
+		}
+	}
+
+	// worker stops right after the last job is done
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.mtx.Lock()
+		s.prune()
+		n := len(s.chats)
+		s.mtx.Unlock()
+
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d idle chat queues are not dropped", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTokenBucketFull(t *testing.T) {
+	tests := []struct {
+		name   string
+		tokens float64
+		since  time.Duration
+		want   bool
+	}{
+		{"refilled", 0, 3 * time.Second, true},
+		{"not refilled yet", 0, time.Second, false},
+		{"in debt", -3, 3 * time.Second, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tokenBucket{tokens: tt.tokens, last: time.Now().Add(-tt.since)}
+			if got := b.full(1, 3); got != tt.want {
+				t.Errorf("full() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want time.Duration
+	}{
+		{"flood error", tgbotapi.Error{Message: "Too Many Requests: retry after 5", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 5}}, 5 * time.Second},
+		{"flood error of file upload", errors.New("Too Many Requests: retry after 12"), 12 * time.Second},
+		{"api error without retry after", tgbotapi.Error{Message: "Bad Request: chat not found"}, 0},
+		{"other error", errors.New("connection refused"), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryAfter(tt.err); got != tt.want {
+				t.Errorf("retryAfter() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenBucketReserve(t *testing.T) {
+	var b tokenBucket
+
+	// burst is available right away
+	for i := 0; i < 3; i++ {
+		if wait := b.reserve(1, 3); wait != 0 {
+			t.Fatalf("reserve() #%d = %s, want 0", i, wait)
+		}
+	}
+
+	// the next tokens are reserved one by one
+	for i := 1; i <= 2; i++ {
+		if wait := b.reserve(1, 3); wait < time.Duration(i)*time.Second-100*time.Millisecond || wait > time.Duration(i)*time.Second {
+			t.Errorf("reserve() = %s, want ~%ds", wait, i)
+		}
+	}
+
+	// rate limit is disabled with non-positive rate
+	if wait := b.reserve(0, 3); wait != 0 {
+		t.Errorf("reserve() with zero rate = %s, want 0", wait)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// reserved for "(NN/NN) " chunk number prefix
+const chunkNumberReserve = 8
+
+// openTag is html tag opened in message text
+type openTag struct {
+	name string
+	tag  string
+}
+
+// messageSplitter splits message text into chunks fitting telegram message length limit,
+// chunks are split at newlines when possible, tags opened at chunk end are closed
+// and reopened in the next chunk, so every chunk is valid html
+type messageSplitter struct {
+	limit int
+	html  bool
+
+	chunks []string
+	cur    []byte
+	curLen int
+	stack  []openTag
+
+	// position of the last newline in cur and open tags at that moment
+	breakPos   int
+	breakStack []openTag
+}
+
+// utf16Len returns length of string in UTF-16 code units, like telegram counts it
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+func tagName(tag string) string {
+	name := strings.TrimLeft(tag, "</")
+	if i := strings.IndexAny(name, " \t\n>"); i >= 0 {
+		name = name[:i]
+	}
+	return strings.ToLower(name)
+}
+
+func openingTags(stack []openTag) (s string) {
+	for _, t := range stack {
+		s += t.tag
+	}
+	return
+}
+
+func closingTags(stack []openTag) (s string) {
+	for i := len(stack) - 1; i >= 0; i-- {
+		s += "</" + stack[i].name + ">"
+	}
+	return
+}
+
+// nextToken returns tag, entity or single rune from the beginning of html text
+func nextToken(s string, html bool) string {
+	if html {
+		switch s[0] {
+		case '<':
+			if i := strings.IndexByte(s, '>'); i > 0 {
+				return s[:i+1]
+			}
+		case '&':
+			if i := strings.IndexByte(s, ';'); i > 0 && i < 32 && !strings.ContainsAny(s[1:i], " \n<&") {
+				return s[:i+1]
+			}
+		}
+	}
+
+	for i := range s {
+		if i > 0 {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// updateStack returns open tags after token is written
+func updateStack(stack []openTag, token string) []openTag {
+	if len(token) < 3 || token[0] != '<' {
+		return stack
+	}
+
+	name := tagName(token)
+	if token[1] != '/' {
+		return append(append([]openTag{}, stack...), openTag{name: name, tag: token})
+	}
+
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i].name == name {
+			return append(append([]openTag{}, stack[:i]...), stack[i+1:]...)
+		}
+	}
+	return stack
+}
+
+// cut finishes current chunk at pos, rest of current text goes to the next chunk
+func (ms *messageSplitter) cut(pos int, stack []openTag, skip int) {
+	rest := string(ms.cur[pos+skip:])
+	ms.chunks = append(ms.chunks, string(ms.cur[:pos])+closingTags(stack))
+
+	ms.cur = []byte(openingTags(stack) + rest)
+	ms.curLen = utf16Len(string(ms.cur))
+	ms.breakPos = -1
+	ms.breakStack = nil
+}
+
+func (ms *messageSplitter) write(token string) {
+	stack := updateStack(ms.stack, token)
+	tokenLen := utf16Len(token)
+
+	for ms.curLen+tokenLen+utf16Len(closingTags(stack)) > ms.limit && ms.curLen > utf16Len(openingTags(ms.stack)) {
+		if ms.breakPos > 0 {
+			// split at the last newline
+			ms.cut(ms.breakPos, ms.breakStack, 1)
+			continue
+		}
+
+		// hard split of too long line
+		ms.cut(len(ms.cur), ms.stack, 0)
+	}
+
+	if token == "\n" {
+		ms.breakPos = len(ms.cur)
+		ms.breakStack = ms.stack
+	}
+
+	ms.cur = append(ms.cur, token...)
+	ms.curLen += tokenLen
+	ms.stack = stack
+}
+
+// splitMessage splits message text into chunks no longer than telegram limit
+// html tags and entities are never broken if parse mode is html
+func splitMessage(text string, parseMode string) []string {
+	ms := messageSplitter{
+		limit:    maxMessageTextLength,
+		html:     parseMode == tgbotapi.ModeHTML,
+		breakPos: -1,
+	}
+	if cfg().MessageChunkNumbers {
+		ms.limit -= chunkNumberReserve
+	}
+
+	for len(text) > 0 {
+		token := nextToken(text, ms.html)
+		ms.write(token)
+		text = text[len(token):]
+	}
+	ms.chunks = append(ms.chunks, string(ms.cur))
+
+	if cfg().MessageChunkNumbers && len(ms.chunks) > 1 {
+		for i := range ms.chunks {
+			ms.chunks[i] = fmt.Sprintf("(%d/%d) %s", i+1, len(ms.chunks), ms.chunks[i])
+		}
+	}
+
+	return ms.chunks
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+func TestUTF16Len(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{"", 0},
+		{"abc", 3},
+		{"привет", 6},
+		{"😀", 2},
+		{"a😀b", 4},
+	}
+
+	for _, tt := range tests {
+		if got := utf16Len(tt.s); got != tt.want {
+			t.Errorf("utf16Len(%q) = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	a := strings.Repeat("a", 3000)
+	b := strings.Repeat("b", 3000)
+
+	tests := []struct {
+		name         string
+		text         string
+		parseMode    string
+		chunkNumbers bool
+		want         []string
+	}{
+		{
+			name: "short message",
+			text: "hello",
+			want: []string{"hello"},
+		},
+		{
+			name: "split at newline",
+			text: a + "\n" + b,
+			want: []string{a, b},
+		},
+		{
+			name: "hard split of long line",
+			text: strings.Repeat("a", 5000),
+			want: []string{strings.Repeat("a", 4096), strings.Repeat("a", 904)},
+		},
+		{
+			name: "utf16 length",
+			text: strings.Repeat("😀", 3000),
+			want: []string{strings.Repeat("😀", 2048), strings.Repeat("😀", 952)},
+		},
+		{
+			name:      "html tags reopened",
+			text:      "<b>" + a + "\n" + b + "</b>",
+			parseMode: tgbotapi.ModeHTML,
+			want:      []string{"<b>" + a + "</b>", "<b>" + b + "</b>"},
+		},
+		{
+			name:      "nested html tags reopened",
+			text:      `<b><a href="http://x">` + a + "\n" + b + "</a></b>",
+			parseMode: tgbotapi.ModeHTML,
+			want:      []string{`<b><a href="http://x">` + a + "</a></b>", `<b><a href="http://x">` + b + "</a></b>"},
+		},
+		{
+			name:      "html entities not broken",
+			text:      strings.Repeat("&amp;", 1000),
+			parseMode: tgbotapi.ModeHTML,
+			want:      []string{strings.Repeat("&amp;", 819), strings.Repeat("&amp;", 181)},
+		},
+		{
+			name:         "chunk numbers",
+			text:         a + "\n" + b,
+			chunkNumbers: true,
+			want:         []string{"(1/2) " + a, "(2/2) " + b},
+		},
+		{
+			name:         "chunk numbers space reserved",
+			text:         strings.Repeat("a", 5000),
+			chunkNumbers: true,
+			want:         []string{"(1/2) " + strings.Repeat("a", 4088), "(2/2) " + strings.Repeat("a", 912)},
+		},
+		{
+			name:         "no number of single chunk",
+			text:         "hello",
+			chunkNumbers: true,
+			want:         []string{"hello"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, func(c *Config) {
+				c.MessageChunkNumbers = tt.chunkNumbers
+			})
+
+			got := splitMessage(tt.text, tt.parseMode)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d chunks, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("chunk %d = %.40q... (%d), want %.40q... (%d)", i, got[i], len(got[i]), tt.want[i], len(tt.want[i]))
+				}
+				if l := utf16Len(got[i]); l > maxMessageTextLength {
+					t.Errorf("chunk %d length %d exceeds limit", i, l)
+				}
+			}
+		})
+	}
+}
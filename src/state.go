@@ -0,0 +1,571 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// State is bot state persisted between restarts
+type State struct {
+	mtx  sync.Mutex
+	path string
+
+	ChatEnvironments map[int64]string              `json:"chat_environments"`
+	ChatTenants      map[int64]string              `json:"chat_tenants"`
+	Acks             map[string]*Ack               `json:"acks"`
+	Escalations      map[string]*PendingEscalation `json:"escalations"`
+	OncallOverrides  []OncallOverride              `json:"oncall_overrides"`
+	// private chats of users by user id and their ids by lowercase username, saved on /start
+	UserChats     map[int]int64  `json:"user_chats"`
+	UserIDs       map[string]int `json:"user_ids"`
+	Subscriptions []Subscription `json:"subscriptions"`
+	// users approved by admins and their pending access requests by user id
+	Users          map[int]*ApprovedUser  `json:"users"`
+	AccessRequests map[int]*AccessRequest `json:"access_requests"`
+	// alert groups of webhook messages by group id, their buttons refer to
+	AlertGroups map[string]*AlertGroup `json:"alert_groups"`
+	// webhooks held by digest and quiet hours routes and alerts reported by their last digest by digest key
+	DigestBuffers  map[string]*DigestBuffer            `json:"digest_buffers"`
+	DigestReported map[string]map[string]ReportedAlert `json:"digest_reported"`
+}
+
+// loadState reads state from file, missing file is not an error
+// empty path means state is kept in memory only
+func loadState(path string) (*State, error) {
+	s := State{
+		path:             path,
+		ChatEnvironments: make(map[int64]string),
+		ChatTenants:      make(map[int64]string),
+		Acks:             make(map[string]*Ack),
+		Escalations:      make(map[string]*PendingEscalation),
+		UserChats:        make(map[int]int64),
+		UserIDs:          make(map[string]int),
+		Users:            make(map[int]*ApprovedUser),
+		AccessRequests:   make(map[int]*AccessRequest),
+		AlertGroups:      make(map[string]*AlertGroup),
+		DigestBuffers:    make(map[string]*DigestBuffer),
+		DigestReported:   make(map[string]map[string]ReportedAlert),
+	}
+
+	if len(path) == 0 {
+		return &s, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state file: %s", err)
+	}
+
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("error parsing state file: %s", err)
+	}
+
+	if s.ChatEnvironments == nil {
+		s.ChatEnvironments = make(map[int64]string)
+	}
+	if s.ChatTenants == nil {
+		s.ChatTenants = make(map[int64]string)
+	}
+	if s.Acks == nil {
+		s.Acks = make(map[string]*Ack)
+	}
+	if s.Escalations == nil {
+		s.Escalations = make(map[string]*PendingEscalation)
+	}
+	if s.UserChats == nil {
+		s.UserChats = make(map[int]int64)
+	}
+	if s.UserIDs == nil {
+		s.UserIDs = make(map[string]int)
+	}
+	if s.Users == nil {
+		s.Users = make(map[int]*ApprovedUser)
+	}
+	if s.AccessRequests == nil {
+		s.AccessRequests = make(map[int]*AccessRequest)
+	}
+	if s.AlertGroups == nil {
+		s.AlertGroups = make(map[string]*AlertGroup)
+	}
+	if s.DigestBuffers == nil {
+		s.DigestBuffers = make(map[string]*DigestBuffer)
+	}
+	if s.DigestReported == nil {
+		s.DigestReported = make(map[string]map[string]ReportedAlert)
+	}
+
+	return &s, nil
+}
+
+// statelessFeatures returns enabled features which lose their data on restart
+// if state file is not set
+func statelessFeatures(c *Config) (features []string) {
+	if len(c.StateFile) > 0 {
+		return nil
+	}
+
+	if !c.DisableHTTP {
+		features = append(features, "acks and buttons of webhook messages")
+	}
+	if len(c.environments) > 1 {
+		features = append(features, "chat environments")
+	}
+	if len(c.Tenants) > 0 {
+		features = append(features, "chat tenants")
+	}
+	if len(c.escalations) > 0 {
+		features = append(features, "pending escalations")
+	}
+	if len(c.rotations) > 0 {
+		features = append(features, "on-call overrides")
+	}
+	for _, r := range c.routes {
+		if r.Digest != nil || (r.quiet != nil && r.quiet.Mode == quietModeHold) {
+			features = append(features, "webhooks held by digests and quiet hours")
+			break
+		}
+	}
+	if c.AdminChatID != 0 {
+		features = append(features, "access requests and approved users")
+	}
+	features = append(features, "user subscriptions")
+
+	return
+}
+
+// save writes state to file, must be called with s.mtx held
+func (s *State) save() error {
+	if len(s.path) == 0 {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling state: %s", err)
+	}
+
+	// write to temp file first, so state is never left half written
+	tmpPath := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, b, 0600); err != nil {
+		return fmt.Errorf("error writing state file: %s", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("error writing state file: %s", err)
+	}
+
+	return nil
+}
+
+func (s *State) GetChatEnvironment(chatID int64) string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.ChatEnvironments[chatID]
+}
+
+func (s *State) SetChatEnvironment(chatID int64, name string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.ChatEnvironments[chatID] = name
+	return s.save()
+}
+
+func (s *State) GetChatTenant(chatID int64) string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.ChatTenants[chatID]
+}
+
+func (s *State) SetChatTenant(chatID int64, name string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.ChatTenants[chatID] = name
+	return s.save()
+}
+
+// GetAck returns acknowledgement of alert group or nil if not acked
+func (s *State) GetAck(groupKey string) *Ack {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if a, ok := s.Acks[groupKey]; ok {
+		ack := *a
+		return &ack
+	}
+	return nil
+}
+
+// GetAckByFingerprint returns acknowledgement of alert group containing alert
+// with given fingerprint or nil if not acked
+func (s *State) GetAckByFingerprint(fingerprint string) *Ack {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, a := range s.Acks {
+		for _, fp := range a.Fingerprints {
+			if fp == fingerprint {
+				ack := *a
+				return &ack
+			}
+		}
+	}
+	return nil
+}
+
+func (s *State) SetAck(groupKey string, ack Ack) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.Acks[groupKey] = &ack
+	return s.save()
+}
+
+func (s *State) RemoveAck(groupKey string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, ok := s.Acks[groupKey]; !ok {
+		return nil
+	}
+
+	delete(s.Acks, groupKey)
+	return s.save()
+}
+
+// GetEscalations returns copy of pending escalations by group key
+func (s *State) GetEscalations() map[string]*PendingEscalation {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	escalations := make(map[string]*PendingEscalation, len(s.Escalations))
+	for k, e := range s.Escalations {
+		escalation := *e
+		escalations[k] = &escalation
+	}
+	return escalations
+}
+
+// StartEscalation adds escalation of alert group,
+// only message and fingerprints are updated if group is already escalated
+func (s *State) StartEscalation(groupKey string, e PendingEscalation) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if old, ok := s.Escalations[groupKey]; ok && old.Policy == e.Policy {
+		old.Fingerprints = e.Fingerprints
+		old.Text = e.Text
+		old.ParseMode = e.ParseMode
+		return s.save()
+	}
+
+	s.Escalations[groupKey] = &e
+	return s.save()
+}
+
+func (s *State) SetEscalation(groupKey string, e PendingEscalation) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	// escalation could be cancelled while its step was taken
+	if _, ok := s.Escalations[groupKey]; !ok {
+		return nil
+	}
+
+	s.Escalations[groupKey] = &e
+	return s.save()
+}
+
+func (s *State) RemoveEscalation(groupKey string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, ok := s.Escalations[groupKey]; !ok {
+		return nil
+	}
+
+	delete(s.Escalations, groupKey)
+	return s.save()
+}
+
+// GetOncallOverrides returns overrides of rotation
+func (s *State) GetOncallOverrides(rotation string) (overrides []OncallOverride) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, o := range s.OncallOverrides {
+		if o.Rotation == rotation {
+			overrides = append(overrides, o)
+		}
+	}
+	return
+}
+
+// AddOncallOverride adds override of rotation, expired overrides are removed
+func (s *State) AddOncallOverride(o OncallOverride) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := time.Now()
+	var overrides []OncallOverride
+	for _, old := range s.OncallOverrides {
+		if old.End.After(now) {
+			overrides = append(overrides, old)
+		}
+	}
+	s.OncallOverrides = append(overrides, o)
+
+	return s.save()
+}
+
+// GetUserChat returns private chat of user
+func (s *State) GetUserChat(userID int) (chatID int64, ok bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	chatID, ok = s.UserChats[userID]
+	return
+}
+
+// SetUserChat saves private chat of user, username is saved to look user up by it
+func (s *State) SetUserChat(user *tgbotapi.User, chatID int64) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	userName := strings.ToLower(user.UserName)
+	if s.UserChats[user.ID] == chatID && (len(userName) == 0 || s.UserIDs[userName] == user.ID) {
+		return nil
+	}
+
+	s.UserChats[user.ID] = chatID
+	if len(userName) > 0 {
+		s.UserIDs[userName] = user.ID
+	}
+	return s.save()
+}
+
+// GetUserID returns id of user who started the bot by username
+func (s *State) GetUserID(userName string) (userID int, ok bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	userID, ok = s.UserIDs[strings.ToLower(userName)]
+	return
+}
+
+// GetSubscriptions returns copy of all users subscriptions
+func (s *State) GetSubscriptions() []Subscription {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return append([]Subscription{}, s.Subscriptions...)
+}
+
+func (s *State) AddSubscription(sub Subscription) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.Subscriptions = append(s.Subscriptions, sub)
+	return s.save()
+}
+
+// RemoveSubscription removes subscription of user by id
+func (s *State) RemoveSubscription(id string, userID int) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for i, sub := range s.Subscriptions {
+		if sub.ID == id && sub.UserID == userID {
+			s.Subscriptions = append(s.Subscriptions[:i], s.Subscriptions[i+1:]...)
+			return s.save()
+		}
+	}
+	return nil
+}
+
+// AddAccessRequest adds access request of user unless one is already pending
+func (s *State) AddAccessRequest(r AccessRequest) (added bool, err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, ok := s.AccessRequests[r.UserID]; ok {
+		return false, nil
+	}
+
+	s.AccessRequests[r.UserID] = &r
+	return true, s.save()
+}
+
+// RemoveAccessRequest removes access request of user and returns it,
+// nil is returned if request is not found (e.g. already processed)
+func (s *State) RemoveAccessRequest(userID int) (*AccessRequest, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	r, ok := s.AccessRequests[userID]
+	if !ok {
+		return nil, nil
+	}
+
+	delete(s.AccessRequests, userID)
+	return r, s.save()
+}
+
+// GetApprovedUser returns approved user by id or nil if not found
+func (s *State) GetApprovedUser(userID int) *ApprovedUser {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if u, ok := s.Users[userID]; ok {
+		user := *u
+		return &user
+	}
+	return nil
+}
+
+// GetApprovedUsers returns approved users sorted by approval time
+func (s *State) GetApprovedUsers() (users []ApprovedUser) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, u := range s.Users {
+		users = append(users, *u)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].At.Before(users[j].At)
+	})
+	return
+}
+
+func (s *State) SetApprovedUser(u ApprovedUser) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.Users[u.ID] = &u
+	return s.save()
+}
+
+func (s *State) RemoveApprovedUser(userID int) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, ok := s.Users[userID]; !ok {
+		return nil
+	}
+
+	delete(s.Users, userID)
+	return s.save()
+}
+
+// GetAlertGroup returns alert group by id or nil if not found
+func (s *State) GetAlertGroup(id string) *AlertGroup {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if g, ok := s.AlertGroups[id]; ok {
+		group := *g
+		return &group
+	}
+	return nil
+}
+
+// SetAlertGroup saves alert group, groups not updated for callback ttl are removed
+// as buttons of their messages are expired anyway
+func (s *State) SetAlertGroup(id string, g AlertGroup) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	expired := time.Now().Add(-cfg().CallbackTTL)
+	for k, old := range s.AlertGroups {
+		if old.Updated.Before(expired) {
+			delete(s.AlertGroups, k)
+		}
+	}
+
+	s.AlertGroups[id] = &g
+	return s.save()
+}
+
+func (s *State) RemoveAlertGroup(id string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, ok := s.AlertGroups[id]; !ok {
+		return nil
+	}
+
+	delete(s.AlertGroups, id)
+	return s.save()
+}
+
+// GetDigestBuffers returns copy of digest buffers
+func (s *State) GetDigestBuffers() (buffers []DigestBuffer) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, b := range s.DigestBuffers {
+		buffers = append(buffers, b.copy())
+	}
+	return
+}
+
+func (s *State) SetDigestBuffer(key string, b DigestBuffer) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.DigestBuffers[key] = &b
+	return s.save()
+}
+
+func (s *State) RemoveDigestBuffer(key string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, ok := s.DigestBuffers[key]; !ok {
+		return nil
+	}
+
+	delete(s.DigestBuffers, key)
+	return s.save()
+}
+
+// GetDigestReported returns copy of alerts reported as firing by the last digest
+func (s *State) GetDigestReported(key string) map[string]ReportedAlert {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	reported := make(map[string]ReportedAlert, len(s.DigestReported[key]))
+	for k, a := range s.DigestReported[key] {
+		reported[k] = a
+	}
+	return reported
+}
+
+// SetDigestReported saves alerts reported as firing by the last digest, empty set is removed
+func (s *State) SetDigestReported(key string, reported map[string]ReportedAlert) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if len(reported) == 0 {
+		if _, ok := s.DigestReported[key]; !ok {
+			return nil
+		}
+		delete(s.DigestReported, key)
+	} else {
+		s.DigestReported[key] = reported
+	}
+	return s.save()
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strings"
+
+	"github.com/prometheus/alertmanager/pkg/labels"
+	alerttmpl "github.com/prometheus/alertmanager/template"
+	"github.com/segmentio/ksuid"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// Subscription is user subscription to webhook alerts of environment
+// matching alerts are sent to user private chat
+type Subscription struct {
+	ID       string `json:"id"`
+	UserID   int    `json:"user_id"`
+	User     string `json:"user"`
+	UserName string `json:"username,omitempty"`
+	Env      string `json:"env"`
+	Matchers string `json:"matchers"`
+}
+
+// parseSubscriptionMatchers parses matchers like `service="payments",severity=~"critical|warning"`
+func parseSubscriptionMatchers(s string) (labels.Matchers, error) {
+	matchers, err := labels.ParseMatchers(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	if len(matchers) == 0 {
+		return nil, fmt.Errorf("no matchers set")
+	}
+	return matchers, nil
+}
+
+// matches checks if any webhook alert matches subscription
+func (s Subscription) matches(env *Environment, data alerttmpl.Data) bool {
+	if s.Env != env.Name {
+		return false
+	}
+
+	matchers, err := parseSubscriptionMatchers(s.Matchers)
+	if err != nil {
+		log.Printf("error parsing subscription %s matchers: %s", s.ID, err)
+		return false
+	}
+
+	for _, a := range data.Alerts {
+		if labels.Matchers(matchers).Matches(toLabelSet(a.Labels)) {
+			return true
+		}
+	}
+	return false
+}
+
+// notifySubscribers sends webhook message to private chats of users subscribed to its alerts
+// every user gets one message, users without access to environment or tenant or unknown private chat are skipped
+func notifySubscribers(bot *TelegramBot, env *Environment, tenant *TenantConfig, chatID int64, data alerttmpl.Data, text string, parseMode string, priority int, groupKey string) {
+	notified := make(map[int]bool)
+	for _, s := range bot.State.GetSubscriptions() {
+		// access could be revoked after subscribing
+		user := &tgbotapi.User{ID: s.UserID, UserName: s.UserName}
+		if notified[s.UserID] || !s.matches(env, data) || len(bot.userRole(user)) == 0 || !env.isUserAllowed(user) {
+			continue
+		}
+		if tenant != nil && !tenant.isUserAllowed(user) {
+			continue
+		}
+		notified[s.UserID] = true
+
+		userChat, ok := bot.State.GetUserChat(s.UserID)
+		if !ok || userChat == chatID {
+			continue
+		}
+
+		msg := tgbotapi.NewMessage(userChat, text)
+		msg.ParseMode = parseMode
+		err := sendMessageWithPriority(bot, msg, priority, fmt.Sprintf("%d/%s", s.UserID, groupKey))
+		if err != nil && err != errMessageSuperseded {
+			log.Printf("error sending message to subscriber %s: %s", s.User, err)
+		}
+	}
+}
+
+// subscribe adds subscription of user in environment
+func subscribe(bot *TelegramBot, user *tgbotapi.User, env *Environment, matchers string) (string, error) {
+	if _, err := parseSubscriptionMatchers(matchers); err != nil {
+		return fmt.Sprintf("Wrong matchers: %s\nUsage: /subscribe <matchers>, e.g. /subscribe service=\"payments\",severity=~\"critical|warning\"", err), nil
+	}
+
+	if _, ok := bot.State.GetUserChat(user.ID); !ok {
+		return "I don't know your private chat, send me /start in private chat first.", nil
+	}
+
+	s := Subscription{
+		ID:       ksuid.New().String(),
+		UserID:   user.ID,
+		User:     user.String(),
+		UserName: user.UserName,
+		Env:      env.Name,
+		Matchers: strings.TrimSpace(matchers),
+	}
+	if err := bot.State.AddSubscription(s); err != nil {
+		return "", fmt.Errorf("error saving subscription: %s", err)
+	}
+	log.Printf("user %s subscribed to %s in environment '%s'", s.User, s.Matchers, env.Name)
+
+	return fmt.Sprintf("Subscribed to %s, matching alerts will be sent to you directly.", s.Matchers), nil
+}
+
+// newSubscriptionsMessage returns list of user subscriptions with remove buttons
+func newSubscriptionsMessage(bot *TelegramBot, userID int) (text string, kb *tgbotapi.InlineKeyboardMarkup) {
+	var subs []Subscription
+	for _, s := range bot.State.GetSubscriptions() {
+		if s.UserID == userID {
+			subs = append(subs, s)
+		}
+	}
+	if len(subs) == 0 {
+		return "No subscriptions, use /subscribe <matchers> to add one.", nil
+	}
+
+	var b strings.Builder
+	var rows [][]tgbotapi.InlineKeyboardButton
+	b.WriteString("Subscriptions:\n")
+	for i, s := range subs {
+		fmt.Fprintf(&b, "%d. <code>%s</code>", i+1, html.EscapeString(s.Matchers))
+		if len(cfg().environments) > 1 {
+			fmt.Fprintf(&b, " (%s)", s.Env)
+		}
+		b.WriteString("\n")
+
+		cacheID := ksuid.New().String()
+		bot.Cache.Set(cacheID, Callback{
+			Type: "unsubscribe",
+			Data: map[string]string{"id": s.ID},
+		})
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("Remove %d", i+1), cacheID)))
+	}
+
+	markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return b.String(), &markup
+}
+
+// processUnsubscribeCallback removes subscription and updates subscriptions list
+func processUnsubscribeCallback(bot *TelegramBot, cq *tgbotapi.CallbackQuery, cb Callback) error {
+	if err := bot.State.RemoveSubscription(cb.Data["id"], cq.From.ID); err != nil {
+		return fmt.Errorf("error removing subscription: %s", err)
+	}
+	log.Printf("user %s removed subscription %s", cq.From.String(), cb.Data["id"])
+
+	text, kb := newSubscriptionsMessage(bot, cq.From.ID)
+	msg := tgbotapi.NewEditMessageText(cq.Message.Chat.ID, cq.Message.MessageID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.ReplyMarkup = kb
+	if err := sendMessage(bot, msg); err != nil {
+		return fmt.Errorf("error sending message: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	alerttmpl "github.com/prometheus/alertmanager/template"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+func TestSubscriptionMatches(t *testing.T) {
+	env := &Environment{Name: "prod"}
+	data := alerttmpl.Data{
+		Alerts: alerttmpl.Alerts{
+			{Labels: alerttmpl.KV{"alertname": "NodeDown", "service": "payments", "severity": "warning"}},
+			{Labels: alerttmpl.KV{"alertname": "DiskFull", "service": "billing", "severity": "critical"}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		env      string
+		matchers string
+		want     bool
+		wantErr  bool
+	}{
+		{
+			name:     "any alert matches",
+			env:      "prod",
+			matchers: `service="billing"`,
+			want:     true,
+		},
+		{
+			name:     "all matchers of one alert",
+			env:      "prod",
+			matchers: ` service="payments",severity=~"critical|warning" `,
+			want:     true,
+		},
+		{
+			name:     "matchers of different alerts",
+			env:      "prod",
+			matchers: `service="payments",severity="critical"`,
+		},
+		{
+			name:     "other environment",
+			env:      "staging",
+			matchers: `service="billing"`,
+		},
+		{
+			name:     "empty matchers",
+			env:      "prod",
+			matchers: " ",
+			wantErr:  true,
+		},
+		{
+			name:     "invalid matchers",
+			env:      "prod",
+			matchers: `service=~"("`,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseSubscriptionMatchers(tt.matchers); (err != nil) != tt.wantErr {
+				t.Fatalf("parseSubscriptionMatchers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			s := Subscription{ID: "1", Env: tt.env, Matchers: tt.matchers}
+			if got := s.matches(env, data); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotifySubscribers(t *testing.T) {
+	env := &Environment{Name: "prod", Users: []string{"alice", "bob", "dave"}}
+	setTestConfig(t, func(c *Config) {
+		c.Users = []string{"alice", "carol", "dave"}
+		c.environments = []*Environment{env}
+	})
+	bot, tg := newTestBot(t)
+
+	alice := &tgbotapi.User{ID: 1, UserName: "alice"}
+	// no role
+	bob := &tgbotapi.User{ID: 2, UserName: "bob"}
+	// not allowed in environment
+	carol := &tgbotapi.User{ID: 3, UserName: "carol"}
+	// private chat is not known
+	dave := &tgbotapi.User{ID: 4, UserName: "dave"}
+
+	for _, u := range []*tgbotapi.User{alice, bob, carol} {
+		if err := bot.State.SetUserChat(u, int64(u.ID)); err != nil {
+			t.Fatalf("error saving user chat: %s", err)
+		}
+	}
+
+	for _, sub := range []struct {
+		user     *tgbotapi.User
+		matchers string
+	}{
+		{alice, `service="payments"`},
+		{alice, `alertname="NodeDown"`},
+		{alice, `service="billing"`},
+		{bob, `service="payments"`},
+		{carol, `service="payments"`},
+		{dave, `service="payments"`},
+	} {
+		text, err := subscribe(bot, sub.user, env, sub.matchers)
+		if err != nil {
+			t.Fatalf("subscribe() error = %s", err)
+		}
+		if sub.user == dave {
+			if !strings.Contains(text, "/start") {
+				t.Errorf("got reply %q to user without private chat, want /start hint", text)
+			}
+		} else if !strings.HasPrefix(text, "Subscribed to") {
+			t.Errorf("got reply %q, want subscribed", text)
+		}
+	}
+	if text, _ := subscribe(bot, alice, env, "service"); !strings.HasPrefix(text, "Wrong matchers") {
+		t.Errorf("got reply %q to invalid matchers, want error", text)
+	}
+
+	data := alerttmpl.Data{
+		Alerts: alerttmpl.Alerts{
+			{Labels: alerttmpl.KV{"alertname": "NodeDown", "service": "payments"}},
+		},
+	}
+	notifySubscribers(bot, env, nil, -1, data, "NodeDown", "", alertsPriority(data.Alerts), "group")
+
+	var chats []string
+	for _, r := range tg.sent() {
+		if r.Method == "sendMessage" {
+			chats = append(chats, r.Params.Get("chat_id"))
+		}
+	}
+	sort.Strings(chats)
+	if strings.Join(chats, ",") != "1" {
+		t.Errorf("got messages sent to chats %v, want the only one to alice", chats)
+	}
+
+	// message sent to private chat of subscriber is not duplicated
+	notifySubscribers(bot, env, nil, 1, data, "NodeDown", "", alertsPriority(data.Alerts), "group")
+	if n := len(tg.sent()); n != 1 {
+		t.Errorf("got %d messages, want no message to chat webhook was sent to", n)
+	}
+
+	text, kb := newSubscriptionsMessage(bot, alice.ID)
+	if !strings.Contains(text, `<code>service=&#34;payments&#34;</code>`) || kb == nil || len(kb.InlineKeyboard) != 3 {
+		t.Fatalf("got subscriptions %q, want 3 subscriptions with remove buttons", text)
+	}
+
+	v, err := bot.Cache.Get(*kb.InlineKeyboard[0][0].CallbackData)
+	if err != nil {
+		t.Fatalf("remove button callback not found: %s", err)
+	}
+	cq := &tgbotapi.CallbackQuery{
+		From:    alice,
+		Message: &tgbotapi.Message{MessageID: 10, Chat: &tgbotapi.Chat{ID: 1}},
+	}
+	if err := processUnsubscribeCallback(bot, cq, v.(Callback)); err != nil {
+		t.Fatalf("processUnsubscribeCallback() error = %s", err)
+	}
+	if text, _ := newSubscriptionsMessage(bot, alice.ID); strings.Contains(text, "payments") || !strings.Contains(text, "2. ") {
+		t.Errorf("got subscriptions %q after removing the first one", text)
+	}
+}
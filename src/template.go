@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"path"
+	"path/filepath"
+)
+
+// loadTemplates parses template files once, templates are executed from parsed set
+// all *.tmpl files of template dirs are parsed along with every template,
+// so their define blocks could be shared between templates
+func loadTemplates(paths []string, dirs []string) (map[string]*template.Template, error) {
+	var shared []string
+	for _, dir := range dirs {
+		files, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+		if err != nil {
+			return nil, fmt.Errorf("error listing template dir '%s': %s", dir, err)
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no templates found in template dir '%s'", dir)
+		}
+		shared = append(shared, files...)
+	}
+
+	templates := make(map[string]*template.Template)
+	for _, p := range paths {
+		if len(p) == 0 || templates[p] != nil {
+			continue
+		}
+
+		tmpl := template.New(path.Base(p)).Funcs(tmplFuncMap)
+		if len(shared) > 0 {
+			if _, err := tmpl.ParseFiles(shared...); err != nil {
+				return nil, fmt.Errorf("error parsing template dirs: %s", err)
+			}
+		}
+
+		// template file is parsed last to take precedence over shared ones
+		if _, err := tmpl.ParseFiles(p); err != nil {
+			return nil, fmt.Errorf("error parsing template file: %s", err)
+		}
+
+		templates[p] = tmpl
+	}
+
+	return templates, nil
+}
+
+// applyTemplate executes parsed template with funcs set per execution (e.g. externalURL)
+// parsed template is cloned, as html/template can't be cloned after execution
+func applyTemplate(in interface{}, templatePath string, funcs ...template.FuncMap) (string, error) {
+	tmpl := cfg().templates[templatePath]
+	if tmpl == nil {
+		err := fmt.Errorf("template '%s' is not loaded", templatePath)
+		log.Println(err)
+		return "", err
+	}
+
+	tmpl, err := tmpl.Clone()
+	if err != nil {
+		log.Printf("error cloning template: %s", err)
+		return "", err
+	}
+	for _, f := range funcs {
+		tmpl.Funcs(f)
+	}
+
+	b := bytes.Buffer{}
+	err = tmpl.Execute(&b, in)
+	if err != nil {
+		log.Printf("error executing template: %s", err)
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// externalURLFunc sets externalURL template function
+func externalURLFunc(u string) template.FuncMap {
+	return template.FuncMap{
+		"externalURL": func() string { return u },
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/prometheus/alertmanager/api/v2/models"
+	alerttmpl "github.com/prometheus/alertmanager/template"
+)
+
+var tmplFuncMap = template.FuncMap{
+	"ToUpper":    strings.ToUpper,
+	"ToLower":    strings.ToLower,
+	"KindOf":     KindOf,
+	"FormatDate": FormatDate,
+
+	// durations
+	"since":              since,
+	"humanize":           humanize,
+	"humanize1024":       humanize1024,
+	"humanizeDuration":   humanizeDuration,
+	"humanizePercentage": humanizePercentage,
+
+	// labels
+	"sortedPairs": sortedPairs,
+	"removeLabels": func(names []string, in interface{}) alerttmpl.KV {
+		return toKV(in).Remove(names)
+	},
+
+	// escaping
+	"toJson":     toJSON,
+	"urlEscape":  url.QueryEscape,
+	"pathEscape": url.PathEscape,
+	"escapeHTML": escapeHTML,
+
+	// bot config
+	"botConfig": botConfig,
+
+	// prometheus query results
+	"first": first,
+	"value": value,
+	"label": label,
+
+	// on-call rotations, overrides from state are set per execution
+	"oncall": oncallFunc(nil),
+
+	// set per execution, see applyTemplate
+	"externalURL": func() string { return "" },
+	"ack":         func(interface{}) *Ack { return nil },
+	"query": func(string, ...interface{}) ([]querySample, error) {
+		return nil, fmt.Errorf("query is not available")
+	},
+	"queryRange": func(string, string, string, ...interface{}) ([]querySeries, error) {
+		return nil, fmt.Errorf("queryRange is not available")
+	},
+}
+
+func init() {
+	// alertmanager default template functions
+	for k, v := range alerttmpl.DefaultFuncs {
+		tmplFuncMap[k] = v
+	}
+}
+
+func KindOf(in interface{}) string {
+	return reflect.TypeOf(in).Kind().String()
+}
+
+func FormatDate(in interface{}) (out string) {
+	t := toTime(in)
+
+	loc, err := time.LoadLocation(cfg().TimeZone)
+	if err != nil {
+		log.Printf("error loading timezone %s: %s\n", cfg().TimeZone, err)
+		return
+	}
+
+	out = t.In(loc).Format(cfg().TimeFormat)
+	return
+}
+
+// toTime converts alert timestamps to time.Time
+func toTime(in interface{}) (t time.Time) {
+	switch in := in.(type) {
+	case time.Time:
+		t = in
+	case *strfmt.DateTime:
+		if in != nil {
+			t = time.Time(*in)
+		}
+	case strfmt.DateTime:
+		t = time.Time(in)
+	}
+	return
+}
+
+// toFloat converts template value to float64
+// durations are converted to seconds
+func toFloat(in interface{}) (float64, error) {
+	switch v := in.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case time.Duration:
+		return v.Seconds(), nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	default:
+		return 0, fmt.Errorf("can't convert %T to float", in)
+	}
+}
+
+// since returns duration passed since alert timestamp
+func since(in interface{}) time.Duration {
+	return time.Since(toTime(in)).Round(time.Second)
+}
+
+// humanize, humanize1024, humanizeDuration & humanizePercentage
+// behave like prometheus template functions of the same name
+
+func humanize(in interface{}) (string, error) {
+	v, err := toFloat(in)
+	if err != nil {
+		return "", err
+	}
+
+	if v == 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Sprintf("%.4g", v), nil
+	}
+
+	prefix := ""
+	if math.Abs(v) >= 1 {
+		for _, p := range []string{"k", "M", "G", "T", "P", "E", "Z", "Y"} {
+			if math.Abs(v) < 1000 {
+				break
+			}
+			prefix = p
+			v /= 1000
+		}
+		return fmt.Sprintf("%.4g%s", v, prefix), nil
+	}
+
+	for _, p := range []string{"m", "u", "n", "p", "f", "a", "z", "y"} {
+		if math.Abs(v) >= 1 {
+			break
+		}
+		prefix = p
+		v *= 1000
+	}
+	return fmt.Sprintf("%.4g%s", v, prefix), nil
+}
+
+func humanize1024(in interface{}) (string, error) {
+	v, err := toFloat(in)
+	if err != nil {
+		return "", err
+	}
+
+	if math.Abs(v) <= 1 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Sprintf("%.4g", v), nil
+	}
+
+	prefix := ""
+	for _, p := range []string{"ki", "Mi", "Gi", "Ti", "Pi", "Ei", "Zi", "Yi"} {
+		if math.Abs(v) < 1024 {
+			break
+		}
+		prefix = p
+		v /= 1024
+	}
+	return fmt.Sprintf("%.4g%s", v, prefix), nil
+}
+
+func humanizeDuration(in interface{}) (string, error) {
+	v, err := toFloat(in)
+	if err != nil {
+		return "", err
+	}
+
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Sprintf("%.4g", v), nil
+	}
+	if v == 0 {
+		return "0s", nil
+	}
+
+	if math.Abs(v) >= 1 {
+		sign := ""
+		if v < 0 {
+			sign = "-"
+			v = -v
+		}
+
+		d := int64(v)
+		seconds := d % 60
+		minutes := (d / 60) % 60
+		hours := (d / 60 / 60) % 24
+		days := d / 60 / 60 / 24
+
+		switch {
+		case days != 0:
+			return fmt.Sprintf("%s%dd %dh %dm %ds", sign, days, hours, minutes, seconds), nil
+		case hours != 0:
+			return fmt.Sprintf("%s%dh %dm %ds", sign, hours, minutes, seconds), nil
+		case minutes != 0:
+			return fmt.Sprintf("%s%dm %ds", sign, minutes, seconds), nil
+		}
+		return fmt.Sprintf("%s%.4gs", sign, v), nil
+	}
+
+	prefix := ""
+	for _, p := range []string{"m", "u", "n", "p", "f", "a", "z", "y"} {
+		if math.Abs(v) >= 1 {
+			break
+		}
+		prefix = p
+		v *= 1000
+	}
+	return fmt.Sprintf("%.4g%ss", v, prefix), nil
+}
+
+func humanizePercentage(in interface{}) (string, error) {
+	v, err := toFloat(in)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%.4g%%", v*100), nil
+}
+
+// toKV converts labels of webhook and gettable alerts to alertmanager KV
+func toKV(in interface{}) alerttmpl.KV {
+	switch in := in.(type) {
+	case alerttmpl.KV:
+		return in
+	case models.LabelSet:
+		return alerttmpl.KV(in)
+	case map[string]string:
+		return alerttmpl.KV(in)
+	}
+	return alerttmpl.KV{}
+}
+
+// sortedPairs returns labels sorted by name, like KV.SortedPairs
+// but works with gettable alerts labels as well
+func sortedPairs(in interface{}) alerttmpl.Pairs {
+	kv := toKV(in)
+
+	pairs := make(alerttmpl.Pairs, 0, len(kv))
+	for k, v := range kv {
+		pairs = append(pairs, alerttmpl.Pair{Name: k, Value: v})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Name < pairs[j].Name
+	})
+
+	return pairs
+}
+
+func toJSON(in interface{}) (string, error) {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// escapeHTML escapes only characters telegram requires to be escaped in HTML mode,
+// result is not escaped again, so it could be concatenated with markup
+func escapeHTML(s string) template.HTML {
+	return template.HTML(strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;").Replace(s))
+}
+
+// templateConfig is a part of bot config available in templates
+type templateConfig struct {
+	TimeZone        string
+	TimeFormat      string
+	SilenceDuration time.Duration
+	Environments    []string
+}
+
+func botConfig() templateConfig {
+	c := cfg()
+
+	tc := templateConfig{
+		TimeZone:        c.TimeZone,
+		TimeFormat:      c.TimeFormat,
+		SilenceDuration: c.SilenceDuration,
+	}
+	for _, env := range c.environments {
+		tc.Environments = append(tc.Environments, env.Name)
+	}
+
+	return tc
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/prometheus/alertmanager/api/v2/models"
+	alerttmpl "github.com/prometheus/alertmanager/template"
+)
+
+func TestHumanizeFuncs(t *testing.T) {
+	tests := []struct {
+		name    string
+		f       func(interface{}) (string, error)
+		in      interface{}
+		want    string
+		wantErr bool
+	}{
+		{name: "humanize zero", f: humanize, in: 0, want: "0"},
+		{name: "humanize small", f: humanize, in: 12.0, want: "12"},
+		{name: "humanize kilo", f: humanize, in: 1234, want: "1.234k"},
+		{name: "humanize mega", f: humanize, in: int64(1234567), want: "1.235M"},
+		{name: "humanize negative", f: humanize, in: -1500.0, want: "-1.5k"},
+		{name: "humanize milli", f: humanize, in: 0.001234, want: "1.234m"},
+		{name: "humanize string", f: humanize, in: "2048", want: "2.048k"},
+		{name: "humanize invalid", f: humanize, in: true, wantErr: true},
+		{name: "humanize1024 small", f: humanize1024, in: 512, want: "512"},
+		{name: "humanize1024 fraction", f: humanize1024, in: 0.5, want: "0.5"},
+		{name: "humanize1024 kibi", f: humanize1024, in: 1024, want: "1ki"},
+		{name: "humanize1024 mebi", f: humanize1024, in: 1572864, want: "1.5Mi"},
+		{name: "humanizeDuration zero", f: humanizeDuration, in: 0, want: "0s"},
+		{name: "humanizeDuration seconds", f: humanizeDuration, in: 1.5, want: "1.5s"},
+		{name: "humanizeDuration minutes", f: humanizeDuration, in: 61, want: "1m 1s"},
+		{name: "humanizeDuration hours", f: humanizeDuration, in: 3661, want: "1h 1m 1s"},
+		{name: "humanizeDuration days", f: humanizeDuration, in: 90061, want: "1d 1h 1m 1s"},
+		{name: "humanizeDuration negative", f: humanizeDuration, in: -61, want: "-1m 1s"},
+		{name: "humanizeDuration milli", f: humanizeDuration, in: 0.25, want: "250ms"},
+		{name: "humanizeDuration duration", f: humanizeDuration, in: 90 * time.Second, want: "1m 30s"},
+		{name: "humanizePercentage", f: humanizePercentage, in: 0.1234, want: "12.34%"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.f(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortedPairs(t *testing.T) {
+	want := alerttmpl.Pairs{{Name: "alertname", Value: "NodeDown"}, {Name: "instance", Value: "node1"}}
+
+	for _, in := range []interface{}{
+		alerttmpl.KV{"instance": "node1", "alertname": "NodeDown"},
+		models.LabelSet{"instance": "node1", "alertname": "NodeDown"},
+		map[string]string{"instance": "node1", "alertname": "NodeDown"},
+	} {
+		if got := sortedPairs(in); !reflect.DeepEqual(got, want) {
+			t.Errorf("sortedPairs(%T) = %v, want %v", in, got, want)
+		}
+	}
+
+	if got := sortedPairs(nil); len(got) != 0 {
+		t.Errorf("sortedPairs(nil) = %v, want empty", got)
+	}
+}
+
+func TestFormatDate(t *testing.T) {
+	setTestConfig(t, func(c *Config) {
+		c.TimeZone = "Europe/Moscow"
+		c.TimeFormat = "2006-01-02 15:04:05"
+	})
+
+	ts := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	dt := strfmt.DateTime(ts)
+	for _, in := range []interface{}{ts, dt, &dt} {
+		if got := FormatDate(in); got != "2021-01-01 15:00:00" {
+			t.Errorf("FormatDate(%T) = %q, want %q", in, got, "2021-01-01 15:00:00")
+		}
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	setTestConfig(t, func(c *Config) {
+		c.environments = []*Environment{{Name: "prod"}, {Name: "staging"}}
+	})
+
+	tests := []struct {
+		name string
+		tmpl string
+		in   interface{}
+		want string
+	}{
+		{
+			name: "alertmanager default funcs",
+			tmpl: `{{ title "node down" }} {{ join "," (stringSlice "a" "b") }} {{ "a-b" | reReplaceAll "-" "_" }}`,
+			want: "Node Down a,b a_b",
+		},
+		{
+			name: "remove labels",
+			tmpl: `{{ range (removeLabels (stringSlice "instance") .).SortedPairs }}{{ .Name }}={{ .Value }} {{ end }}`,
+			in:   map[string]string{"alertname": "NodeDown", "instance": "node1", "job": "node"},
+			want: "alertname=NodeDown job=node ",
+		},
+		{
+			name: "escape html",
+			tmpl: `<b>{{ escapeHTML . }}</b>`,
+			in:   `<a href="x">&</a>`,
+			want: "<b>&lt;a href=&quot;x&quot;&gt;&amp;&lt;/a&gt;</b>",
+		},
+		{
+			name: "escaping",
+			tmpl: `{{ toJson . }} {{ urlEscape "a b&c" }} {{ pathEscape "a b/c" }}`,
+			in:   map[string]string{"a": "b"},
+			want: `{&#34;a&#34;:&#34;b&#34;} a&#43;b%26c a%20b%2Fc`,
+		},
+		{
+			name: "bot config",
+			tmpl: `{{ range botConfig.Environments }}{{ . }} {{ end }}`,
+			want: "prod staging ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := template.New("test").Funcs(tmplFuncMap).Parse(tt.tmpl)
+			if err != nil {
+				t.Fatalf("error parsing template: %s", err)
+			}
+
+			var b bytes.Buffer
+			if err := tmpl.Execute(&b, tt.in); err != nil {
+				t.Fatalf("error executing template: %s", err)
+			}
+			if got := b.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
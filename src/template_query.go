@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ReneKroon/ttlcache/v2"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// query results are cached, so alert storms don't flood prometheus with the same queries
+var queryCache = newQueryCache()
+
+func newQueryCache() *ttlcache.Cache {
+	c := ttlcache.NewCache()
+	c.SkipTTLExtensionOnHit(true)
+	return c
+}
+
+// querySample is a single value of query result available in templates
+type querySample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// querySeries is a single series of range query result available in templates
+type querySeries struct {
+	Labels map[string]string
+	Values []queryPoint
+}
+
+type queryPoint struct {
+	Time  time.Time
+	Value float64
+}
+
+// labelsRegexp matches $labels.<NAME> placeholder at the start of query expression part
+var labelsRegexp = regexp.MustCompile(`^\$labels\.([a-zA-Z_][a-zA-Z0-9_]*)`)
+
+// interpolateLabels replaces $labels.<NAME> in query expression with alert label values
+// values are escaped like promql string literals, so they can't break the expression:
+// placeholders inside quoted strings are replaced with escaped value, other ones with quoted value
+func interpolateLabels(expr string, labels ...interface{}) (string, error) {
+	if len(labels) == 0 {
+		return expr, nil
+	}
+
+	kv := toKV(labels[0])
+
+	var b strings.Builder
+	// quote of string the current char is in, 0 if outside of string
+	var quote byte
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case quote != 0 && quote != '`' && c == '\\' && i+1 < len(expr):
+			b.WriteString(expr[i : i+2])
+			i += 2
+			continue
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\'' || c == '`'):
+			quote = c
+		case c == '$':
+			if m := labelsRegexp.FindStringSubmatchIndex(expr[i:]); m != nil {
+				name := expr[i+m[2] : i+m[3]]
+				v, err := escapeLabelValue(kv[name], quote)
+				if err != nil {
+					return "", fmt.Errorf("error interpolating label '%s': %s", name, err)
+				}
+				b.WriteString(v)
+				i += m[1]
+				continue
+			}
+		}
+		b.WriteByte(c)
+		i++
+	}
+
+	return b.String(), nil
+}
+
+// escapeLabelValue returns label value escaped for promql string of quote,
+// quoted with double quotes if quote is 0
+// raw strings have no escapes, so values are inserted as is unless they contain backtick
+func escapeLabelValue(v string, quote byte) (string, error) {
+	q := strconv.Quote(v)
+	switch quote {
+	case 0:
+		return q, nil
+	case '"':
+		return q[1 : len(q)-1], nil
+	case '\'':
+		q = strings.ReplaceAll(q[1:len(q)-1], `\"`, `"`)
+		return strings.ReplaceAll(q, "'", `\'`), nil
+	}
+	if strings.Contains(v, "`") {
+		return "", fmt.Errorf("value with backtick can't be used in raw string")
+	}
+	return v, nil
+}
+
+// alertPrometheus returns prometheus server alert with given labels originates from,
+// the first configured one if not found
+func (env *Environment) alertPrometheus(labels ...interface{}) *PrometheusServer {
+	if len(labels) > 0 {
+		kv := toKV(labels[0])
+		for _, srv := range env.Prometheus {
+			if len(srv.ExternalLabels) == 0 {
+				continue
+			}
+
+			matched := true
+			for k, v := range srv.ExternalLabels {
+				if kv[k] != v {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				return srv
+			}
+		}
+	}
+
+	return env.Prometheus[0]
+}
+
+// cachedQuery runs query against prometheus server unless its result is cached
+func cachedQuery(ctx context.Context, srv *PrometheusServer, key string, f func(ctx context.Context) (model.Value, v1.Warnings, error)) (model.Value, error) {
+	var orgID string
+	if t := tenantFromContext(ctx); t != nil {
+		orgID = t.OrgID
+	}
+	key = fmt.Sprintf("%s/%s/%s", srv.Name, orgID, key)
+
+	if v, err := queryCache.Get(key); err == nil {
+		return v.(model.Value), nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg().TemplateQueryTimeout)
+	defer cancel()
+
+	result, warnings, err := f(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error running query on prometheus '%s': %s", srv.Name, err)
+	}
+	for _, w := range warnings {
+		log.Printf("prometheus '%s' query warning: %s", srv.Name, w)
+	}
+
+	queryCache.SetWithTTL(key, result, cfg().TemplateQueryCacheTTL)
+	return result, nil
+}
+
+func metricLabels(m model.Metric) map[string]string {
+	labels := make(map[string]string, len(m))
+	for k, v := range m {
+		labels[string(k)] = string(v)
+	}
+	return labels
+}
+
+// queryFuncs returns template functions running queries against environment prometheus
+// server is selected by external labels of alert labels passed, tenant is taken from ctx
+//
+//	{{ query `node_filesystem_avail_bytes{instance="$labels.instance"}` .Labels }}
+//	{{ queryRange `up{job="$labels.job"}` "1h" "5m" .Labels }}
+func queryFuncs(ctx context.Context, env *Environment) template.FuncMap {
+	return template.FuncMap{
+		"query": func(expr string, labels ...interface{}) ([]querySample, error) {
+			srv := env.alertPrometheus(labels...)
+			expr, err := interpolateLabels(expr, labels...)
+			if err != nil {
+				return nil, err
+			}
+
+			result, err := cachedQuery(ctx, srv, expr, func(ctx context.Context) (model.Value, v1.Warnings, error) {
+				return srv.API.Query(ctx, expr, time.Now())
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			var samples []querySample
+			switch v := result.(type) {
+			case model.Vector:
+				for _, s := range v {
+					samples = append(samples, querySample{
+						Labels: metricLabels(s.Metric),
+						Value:  float64(s.Value),
+					})
+				}
+			case *model.Scalar:
+				samples = append(samples, querySample{Value: float64(v.Value)})
+			default:
+				return nil, fmt.Errorf("unsupported query result type '%s'", result.Type())
+			}
+
+			sort.Slice(samples, func(i, j int) bool {
+				return model.LabelsToSignature(samples[i].Labels) < model.LabelsToSignature(samples[j].Labels)
+			})
+
+			return samples, nil
+		},
+		"queryRange": func(expr string, rangeStr string, stepStr string, labels ...interface{}) ([]querySeries, error) {
+			rng, err := model.ParseDuration(rangeStr)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing query range: %s", err)
+			}
+			step, err := model.ParseDuration(stepStr)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing query step: %s", err)
+			}
+
+			srv := env.alertPrometheus(labels...)
+			expr, err = interpolateLabels(expr, labels...)
+			if err != nil {
+				return nil, err
+			}
+
+			result, err := cachedQuery(ctx, srv, fmt.Sprintf("%s/%s/%s", expr, rangeStr, stepStr), func(ctx context.Context) (model.Value, v1.Warnings, error) {
+				end := time.Now()
+				return srv.API.QueryRange(ctx, expr, v1.Range{
+					Start: end.Add(-time.Duration(rng)),
+					End:   end,
+					Step:  time.Duration(step),
+				})
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			matrix, ok := result.(model.Matrix)
+			if !ok {
+				return nil, fmt.Errorf("unsupported query result type '%s'", result.Type())
+			}
+
+			var series []querySeries
+			for _, s := range matrix {
+				qs := querySeries{
+					Labels: metricLabels(s.Metric),
+				}
+				for _, p := range s.Values {
+					qs.Values = append(qs.Values, queryPoint{
+						Time:  p.Timestamp.Time(),
+						Value: float64(p.Value),
+					})
+				}
+				series = append(series, qs)
+			}
+
+			return series, nil
+		},
+	}
+}
+
+// first, value & label are helpers for query results, like in prometheus templates
+//
+//	{{ query `up` | first | value }}
+func first(samples []querySample) (querySample, error) {
+	if len(samples) == 0 {
+		return querySample{}, fmt.Errorf("first() called on empty query result")
+	}
+	return samples[0], nil
+}
+
+func value(s querySample) float64 {
+	return s.Value
+}
+
+func label(name string, s querySample) string {
+	return s.Labels[name]
+}
@@ -0,0 +1,110 @@
+package main
+
+import "testing"
+
+func TestInterpolateLabels(t *testing.T) {
+	labels := map[string]string{
+		"instance": "host:9100",
+		"job":      "node",
+		"quotes":   `a"b'c`,
+		"slash":    `a\b`,
+		"tick":     "a`b",
+		"inject":   `"} or vector(1) or up{a="`,
+	}
+
+	tests := []struct {
+		name    string
+		expr    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "no placeholders",
+			expr: `up{job="node"}`,
+			want: `up{job="node"}`,
+		},
+		{
+			name: "double quoted",
+			expr: `up{instance="$labels.instance",job="$labels.job"}`,
+			want: `up{instance="host:9100",job="node"}`,
+		},
+		{
+			name: "double quoted escaped",
+			expr: `up{a="$labels.quotes",b="$labels.slash"}`,
+			want: `up{a="a\"b'c",b="a\\b"}`,
+		},
+		{
+			name: "double quoted injection",
+			expr: `up{a="$labels.inject"}`,
+			want: `up{a="\"} or vector(1) or up{a=\""}`,
+		},
+		{
+			name: "escaped quote before placeholder",
+			expr: `up{a="\"$labels.job"}`,
+			want: `up{a="\"node"}`,
+		},
+		{
+			name: "single quoted",
+			expr: `up{instance='$labels.instance'}`,
+			want: `up{instance='host:9100'}`,
+		},
+		{
+			name: "single quoted escaped",
+			expr: `up{a='$labels.quotes',b='$labels.slash'}`,
+			want: `up{a='a"b\'c',b='a\\b'}`,
+		},
+		{
+			name: "raw string",
+			expr: "up{instance=`$labels.instance`,a=`$labels.quotes`}",
+			want: "up{instance=`host:9100`,a=`a\"b'c`}",
+		},
+		{
+			name:    "raw string with backtick",
+			expr:    "up{a=`$labels.tick`}",
+			wantErr: true,
+		},
+		{
+			name: "outside of string",
+			expr: `label_replace(up, "a", $labels.quotes, "", "")`,
+			want: `label_replace(up, "a", "a\"b'c", "", "")`,
+		},
+		{
+			name: "backtick value outside of string",
+			expr: `label_replace(up, "a", $labels.tick, "", "")`,
+			want: "label_replace(up, \"a\", \"a`b\", \"\", \"\")",
+		},
+		{
+			name: "missing label",
+			expr: `up{a="$labels.missing"}`,
+			want: `up{a=""}`,
+		},
+		{
+			name: "dollar without placeholder",
+			expr: `up{a="$job"}`,
+			want: `up{a="$job"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := interpolateLabels(tt.expr, labels)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("interpolateLabels() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("interpolateLabels() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterpolateLabelsNoLabels(t *testing.T) {
+	expr := `up{a="$labels.job"}`
+	got, err := interpolateLabels(expr)
+	if err != nil {
+		t.Fatalf("interpolateLabels() error = %s", err)
+	}
+	if got != expr {
+		t.Errorf("interpolateLabels() = %s, want %s", got, expr)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestTemplate writes template file to dir and returns its path
+func writeTestTemplate(t *testing.T, dir, name, data string) string {
+	t.Helper()
+
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, []byte(data), 0600); err != nil {
+		t.Fatalf("error writing template file: %s", err)
+	}
+	return p
+}
+
+func TestLoadTemplates(t *testing.T) {
+	dir := t.TempDir()
+	shared := filepath.Join(dir, "shared")
+	if err := os.Mkdir(shared, 0700); err != nil {
+		t.Fatalf("error creating template dir: %s", err)
+	}
+	writeTestTemplate(t, shared, "common.tmpl", `{{ define "greeting" }}hello{{ end }}{{ define "name" }}shared{{ end }}`)
+
+	alerts := writeTestTemplate(t, dir, "alerts.tmpl", `{{ template "greeting" }} {{ template "name" }} {{ .Name | ToUpper }}`)
+	// template file takes precedence over shared define blocks
+	override := writeTestTemplate(t, dir, "override.tmpl", `{{ define "name" }}own{{ end }}{{ template "greeting" }} {{ template "name" }}`)
+
+	templates, err := loadTemplates([]string{alerts, override, alerts, ""}, []string{shared})
+	if err != nil {
+		t.Fatalf("loadTemplates() error = %s", err)
+	}
+	if len(templates) != 2 {
+		t.Errorf("got %d templates, want 2", len(templates))
+	}
+	setTestConfig(t, func(c *Config) {
+		c.templates = templates
+	})
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: alerts, want: "hello shared ALERTMANAGER"},
+		{path: override, want: "hello own"},
+	}
+	for _, tt := range tests {
+		// template is executed more than once
+		for i := 0; i < 2; i++ {
+			got, err := applyTemplate(struct{ Name string }{"alertmanager"}, tt.path)
+			if err != nil {
+				t.Fatalf("applyTemplate() error = %s", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		}
+	}
+
+	if _, err := applyTemplate(nil, filepath.Join(dir, "missing.tmpl")); err == nil {
+		t.Errorf("applyTemplate() of not loaded template succeeded, want error")
+	}
+}
+
+func TestLoadTemplatesInvalid(t *testing.T) {
+	dir := t.TempDir()
+	valid := writeTestTemplate(t, dir, "valid.tmpl", `{{ .Name }}`)
+	invalid := writeTestTemplate(t, dir, "invalid.tmpl", `{{ .Name `)
+	unknownFunc := writeTestTemplate(t, dir, "func.tmpl", `{{ .Name | noSuchFunc }}`)
+
+	tests := []struct {
+		name  string
+		paths []string
+		dirs  []string
+	}{
+		{name: "syntax error", paths: []string{invalid}},
+		{name: "unknown function", paths: []string{unknownFunc}},
+		{name: "missing file", paths: []string{filepath.Join(dir, "missing.tmpl")}},
+		{name: "empty template dir", paths: []string{valid}, dirs: []string{t.TempDir()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := loadTemplates(tt.paths, tt.dirs); err == nil {
+				t.Errorf("loadTemplates() succeeded, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// tenantHeader is the header used by Mimir / Cortex to select tenant
+const tenantHeader = "X-Scope-OrgID"
+
+// TenantConfig is alertmanager / prometheus tenant from config file
+// chats are bound to tenant by default, users are allowed to select it
+type TenantConfig struct {
+	Name  string   `yaml:"name"`
+	OrgID string   `yaml:"org_id"`
+	Chats []int64  `yaml:"chats"`
+	Users []string `yaml:"users"`
+}
+
+type tenantContextKey struct{}
+
+func validateTenants(tenants []TenantConfig) error {
+	names := make(map[string]bool)
+	for _, t := range tenants {
+		if len(t.Name) == 0 {
+			return fmt.Errorf("tenant name is not set")
+		}
+		if names[t.Name] {
+			return fmt.Errorf("duplicate tenant name '%s'", t.Name)
+		}
+		names[t.Name] = true
+
+		if len(t.OrgID) == 0 {
+			return fmt.Errorf("org_id is not set for tenant '%s'", t.Name)
+		}
+	}
+	return nil
+}
+
+// withTenant returns context, requests with which are sent on behalf of tenant
+func withTenant(ctx context.Context, t *TenantConfig) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, tenantContextKey{}, t)
+}
+
+// tenantFromContext returns tenant set with withTenant or nil
+func tenantFromContext(ctx context.Context) *TenantConfig {
+	t, _ := ctx.Value(tenantContextKey{}).(*TenantConfig)
+	return t
+}
+
+// isUserAllowed checks user access to tenant by user id or username,
+// tenant without users is allowed to everyone
+func (t *TenantConfig) isUserAllowed(u *tgbotapi.User) bool {
+	return len(t.Users) == 0 || matchesAnyUser(t.Users, u)
+}
+
+// getTenant returns tenant by name or nil if not found
+func getTenant(name string) *TenantConfig {
+	for i := range cfg().Tenants {
+		if cfg().Tenants[i].Name == name {
+			return &cfg().Tenants[i]
+		}
+	}
+	return nil
+}
+
+// chatTenant returns tenant selected in chat, tenant the chat is bound to,
+// or the first configured one; nil if no tenants configured
+func (bot *TelegramBot) chatTenant(chatID int64) *TenantConfig {
+	if len(cfg().Tenants) == 0 {
+		return nil
+	}
+
+	if t := getTenant(bot.State.GetChatTenant(chatID)); t != nil {
+		return t
+	}
+
+	for i := range cfg().Tenants {
+		for _, c := range cfg().Tenants[i].Chats {
+			if c == chatID {
+				return &cfg().Tenants[i]
+			}
+		}
+	}
+
+	return defaultTenant()
+}
+
+// defaultTenant returns the first configured tenant or nil
+func defaultTenant() *TenantConfig {
+	if len(cfg().Tenants) == 0 {
+		return nil
+	}
+	return &cfg().Tenants[0]
+}
+
+// tenantOutputHeader returns header for output of given tenant
+// if more than one tenant is configured
+func tenantOutputHeader(t *TenantConfig) string {
+	if t == nil || len(cfg().Tenants) < 2 {
+		return ""
+	}
+	return "[" + t.Name + "]\n"
+}
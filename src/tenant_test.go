@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+func TestValidateTenants(t *testing.T) {
+	tests := []struct {
+		name    string
+		tenants []TenantConfig
+		wantErr bool
+	}{
+		{
+			name: "no tenants",
+		},
+		{
+			name:    "valid",
+			tenants: []TenantConfig{{Name: "team-a", OrgID: "a"}, {Name: "team-b", OrgID: "b"}},
+		},
+		{
+			name:    "name not set",
+			tenants: []TenantConfig{{OrgID: "a"}},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate name",
+			tenants: []TenantConfig{{Name: "team-a", OrgID: "a"}, {Name: "team-a", OrgID: "b"}},
+			wantErr: true,
+		},
+		{
+			name:    "org_id not set",
+			tenants: []TenantConfig{{Name: "team-a"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateTenants(tt.tenants); (err != nil) != tt.wantErr {
+				t.Errorf("validateTenants() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChatTenant(t *testing.T) {
+	state, err := loadState("")
+	if err != nil {
+		t.Fatalf("error loading state: %s", err)
+	}
+	bot := &TelegramBot{State: state}
+
+	setTestConfig(t, nil)
+	if tenant := bot.chatTenant(1); tenant != nil {
+		t.Errorf("got tenant '%s' without tenants configured, want nil", tenant.Name)
+	}
+
+	setTestConfig(t, func(c *Config) {
+		c.Tenants = []TenantConfig{
+			{Name: "team-a", OrgID: "a"},
+			{Name: "team-b", OrgID: "b", Chats: []int64{2}, Users: []string{"@alice"}},
+		}
+	})
+	if err := state.SetChatTenant(3, "team-a"); err != nil {
+		t.Fatalf("error saving chat tenant: %s", err)
+	}
+	if err := state.SetChatTenant(4, "removed"); err != nil {
+		t.Fatalf("error saving chat tenant: %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		chatID int64
+		want   string
+	}{
+		{
+			name:   "default tenant",
+			chatID: 1,
+			want:   "team-a",
+		},
+		{
+			name:   "bound chat",
+			chatID: 2,
+			want:   "team-b",
+		},
+		{
+			name:   "selected tenant",
+			chatID: 3,
+			want:   "team-a",
+		},
+		{
+			name:   "removed tenant",
+			chatID: 4,
+			want:   "team-a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tenant := bot.chatTenant(tt.chatID); tenant == nil || tenant.Name != tt.want {
+				t.Errorf("got tenant %v, want '%s'", tenant, tt.want)
+			}
+		})
+	}
+
+	teamB := getTenant("team-b")
+	if !teamB.isUserAllowed(&tgbotapi.User{ID: 1, UserName: "alice"}) {
+		t.Errorf("user alice is not allowed to tenant team-b, want allowed")
+	}
+	if teamB.isUserAllowed(&tgbotapi.User{ID: 2, UserName: "bob"}) {
+		t.Errorf("user bob is allowed to tenant team-b, want not allowed")
+	}
+	if h := tenantOutputHeader(teamB); h != "[team-b]\n" {
+		t.Errorf("got header %q, want %q", h, "[team-b]\n")
+	}
+}
+
+func TestTenantHeader(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Values(tenantHeader)
+	}))
+	defer srv.Close()
+
+	rt, err := newRoundTripper(HTTPClientConfig{Headers: map[string]string{tenantHeader: "static"}})
+	if err != nil {
+		t.Fatalf("newRoundTripper() error = %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		tenant *TenantConfig
+		want   string
+	}{
+		{
+			name: "no tenant",
+			want: "static",
+		},
+		{
+			name:   "tenant of context",
+			tenant: &TenantConfig{Name: "team-a", OrgID: "a"},
+			want:   "a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequestWithContext(withTenant(context.Background(), tt.tenant), http.MethodGet, srv.URL, nil)
+			if err != nil {
+				t.Fatalf("error creating request: %s", err)
+			}
+			resp, err := (&http.Client{Transport: rt}).Do(req)
+			if err != nil {
+				t.Fatalf("request error: %s", err)
+			}
+			resp.Body.Close()
+
+			if len(got) != 1 || got[0] != tt.want {
+				t.Errorf("got %s %q, want %q", tenantHeader, got, tt.want)
+			}
+		})
+	}
+}
@@ -4,17 +4,16 @@ import (
 	"time"
 
 	"github.com/ReneKroon/ttlcache/v2"
-	"github.com/prometheus/alertmanager/api/v2/client"
-	"github.com/prometheus/client_golang/api"
 	tgbotapi "gopkg.in/telegram-bot-api.v4"
 )
 
 type TelegramBot struct {
-	BotAPI       *tgbotapi.BotAPI
-	Alertmanager *client.Alertmanager
-	Prometheus   api.Client
-	Cache        ttlcache.SimpleCache
-	StartTime    time.Time
+	BotAPI    *tgbotapi.BotAPI
+	Scheduler *Scheduler
+	Digests   *Digests
+	State     *State
+	Cache     ttlcache.SimpleCache
+	StartTime time.Time
 }
 
 type Callback struct {
@@ -4,26 +4,34 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"html"
 	"log"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-openapi/strfmt"
-	"github.com/prometheus/alertmanager/api/v2/client/alert"
-	"github.com/prometheus/alertmanager/api/v2/client/general"
-	"github.com/prometheus/alertmanager/api/v2/client/silence"
 	"github.com/prometheus/alertmanager/api/v2/models"
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
 	"github.com/segmentio/ksuid"
 	tgbotapi "gopkg.in/telegram-bot-api.v4"
 )
 
 const helpMsg = `
 Available commands:
-/status - show alertmanager & bot status
-/alerts - show active alerts
-/targets - show alerts per target
-/silences - show active silences
+/env - select environment
+/tenant - select tenant
+/status [server] - show alertmanager, prometheus & bot status
+/alerts [json|csv] - show active alerts
+/targets [server] - show alerts per target
+/query [server] <expr> - run prometheus query
+/silences [json] - show active silences
+/oncall - show who is on call now and next
+/oncall override [rotation] @user <duration> - put user on call instead
+/subscribe <matchers> - get matching alerts in private chat
+/subscriptions - show and remove subscriptions
+/users - list users and manage approved ones (admin)
 `
 
 func handleUpdates(bot *TelegramBot) {
@@ -33,63 +41,99 @@ func handleUpdates(bot *TelegramBot) {
 		return
 	}
 
+	// updates are processed in goroutines, so slow apis and chat rate limits
+	// of one chat don't block updates of others
 	for update := range updates {
 		if update.Message != nil {
 			log.Printf("new message from %s: %s", update.Message.From.String(), update.Message.Text)
-			if err := processMessage(bot, update.Message); err != nil {
-				log.Printf("error processing message: %s", err)
-			}
+			go func(m *tgbotapi.Message) {
+				if err := processMessage(bot, m); err != nil {
+					log.Printf("error processing message: %s", err)
+				}
+			}(update.Message)
 			continue
 		}
 		if update.EditedMessage != nil {
 			log.Printf("new edited message from %s: %s", update.EditedMessage.From.String(), update.EditedMessage.Text)
-			if err := processMessage(bot, update.EditedMessage); err != nil {
-				log.Printf("error processing edited message: %s", err)
-			}
+			go func(m *tgbotapi.Message) {
+				if err := processMessage(bot, m); err != nil {
+					log.Printf("error processing edited message: %s", err)
+				}
+			}(update.EditedMessage)
 			continue
 		}
 		if update.CallbackQuery != nil {
-			// get callback data from cache
-			cacheData, err := bot.Cache.Get(update.CallbackQuery.Data)
-			if err != nil {
-				log.Printf("error getting callback data from cache: %s", err)
+			cb, ok := getCallback(bot, update.CallbackQuery.Data)
+			if !ok {
+				log.Printf("callback data '%s' not found, button is expired", update.CallbackQuery.Data)
+				answer := tgbotapi.NewCallbackWithAlert(update.CallbackQuery.ID, "This button has expired.")
+				if _, err := bot.BotAPI.AnswerCallbackQuery(answer); err != nil {
+					log.Printf("error answering callback query: %s", err)
+				}
+				continue
+			}
+			// unauthorized press doesn't invalidate button
+			if !bot.authorizeCallback(update.CallbackQuery, cb) {
 				continue
 			}
 			bot.Cache.Remove(update.CallbackQuery.Data)
 
 			// marshall callback data for logging
-			b, err := json.Marshal(cacheData)
+			b, err := json.Marshal(cb)
 			if err != nil {
 				log.Printf("error marshalling cache data: %s", err)
 				continue
 			}
 
 			// process callback query
+			// callback is taken from cache before, so button is pressed only once
 			log.Printf("new callback query from %s: %s", update.CallbackQuery.From.String(), string(b))
-			if err := processCallbackQuery(bot, update.CallbackQuery, cacheData.(Callback)); err != nil {
-				log.Printf("error processing callback query: %s", err)
-			}
+			go func(cq *tgbotapi.CallbackQuery) {
+				if err := processCallbackQuery(bot, cq, cb); err != nil {
+					log.Printf("error processing callback query: %s", err)
+				}
+			}(update.CallbackQuery)
 			continue
 		}
 		log.Println("cannot parse update data")
 	}
 }
 
+// getCallback returns callback of button by its callback data
+// buttons of alert groups refer to group state, other buttons to callbacks in cache
+func getCallback(bot *TelegramBot, data string) (Callback, bool) {
+	if parts := strings.SplitN(data, ":", 2); len(parts) == 2 && alertGroupCallbacks[parts[0]] {
+		return alertGroupCallback(bot, parts[0], parts[1])
+	}
+
+	cacheData, err := bot.Cache.Get(data)
+	if err != nil {
+		return Callback{}, false
+	}
+	return cacheData.(Callback), true
+}
+
 func processMessage(bot *TelegramBot, m *tgbotapi.Message) error {
 	// api call timeout
-	ctx, cancel := context.WithTimeout(context.Background(), cfg.APITimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg().APITimeout)
 	defer cancel()
 
-	// accept messages only from configured users
-	var updateUserIsAdmin bool
-	for _, u := range cfg.Users {
-		if u == m.From.String() {
-			updateUserIsAdmin = true
-			break
+	// accept messages only from users with role,
+	// unknown users could request access from admins
+	role := bot.userRole(m.From)
+	if len(role) == 0 {
+		msgText := "I can't talk to you, sorry."
+		if cfg().AdminChatID != 0 {
+			msgText += "\nUse /request to request access."
 		}
-	}
-	if !updateUserIsAdmin {
-		msg := tgbotapi.NewMessage(m.Chat.ID, "I can't talk to you, sorry.")
+		if m.IsCommand() && m.Command() == "request" {
+			var err error
+			if msgText, err = requestAccess(bot, m); err != nil {
+				return err
+			}
+		}
+
+		msg := tgbotapi.NewMessage(m.Chat.ID, msgText)
 		if err := sendMessage(bot, msg); err != nil {
 			return fmt.Errorf("error sending message: %s", err)
 		}
@@ -105,12 +149,100 @@ func processMessage(bot *TelegramBot, m *tgbotapi.Message) error {
 		return nil
 	}
 
+	if !hasRole(role, commandRole(m.Command())) {
+		msg := tgbotapi.NewMessage(m.Chat.ID, fmt.Sprintf("You don't have permission to use /%s.", m.Command()))
+		if err := sendMessage(bot, msg); err != nil {
+			return fmt.Errorf("error sending message: %s", err)
+		}
+		return nil
+	}
+
+	// environment & tenant selected in chat
+	env := bot.chatEnvironment(m.Chat.ID)
+	tenant := bot.chatTenant(m.Chat.ID)
+	ctx = withTenant(ctx, tenant)
+	switch m.Command() {
+	case "help", "start", "env", "tenant":
+	default:
+		if !env.isUserAllowed(m.From) {
+			msg := tgbotapi.NewMessage(m.Chat.ID, fmt.Sprintf("You don't have access to environment '%s', use /env to switch.", env.Name))
+			if err := sendMessage(bot, msg); err != nil {
+				return fmt.Errorf("error sending message: %s", err)
+			}
+			return nil
+		}
+		if tenant != nil && !tenant.isUserAllowed(m.From) {
+			msg := tgbotapi.NewMessage(m.Chat.ID, fmt.Sprintf("You don't have access to tenant '%s', use /tenant to switch.", tenant.Name))
+			if err := sendMessage(bot, msg); err != nil {
+				return fmt.Errorf("error sending message: %s", err)
+			}
+			return nil
+		}
+	}
+
+	// answer right away if apis needed for command are unreachable
+	var unreachable string
+	switch m.Command() {
+	case "alerts", "silences", "targets":
+		if msgText, all := env.alertmanagersDown(); all {
+			unreachable = msgText
+		}
+	}
+	switch m.Command() {
+	case "targets", "query":
+		if msgText, all := env.prometheusDown(); all {
+			unreachable += msgText
+		}
+	}
+	if len(unreachable) > 0 {
+		msg := tgbotapi.NewMessage(m.Chat.ID, unreachable)
+		if err := sendMessage(bot, msg); err != nil {
+			return fmt.Errorf("error sending message: %s", err)
+		}
+		return nil
+	}
+
 	// process commands
 	switch m.Command() {
 	case "help", "start":
+		// private chat is remembered to send subscribed alerts to user
+		if m.Command() == "start" && m.Chat.IsPrivate() {
+			if err := bot.State.SetUserChat(m.From, m.Chat.ID); err != nil {
+				log.Printf("error saving user chat: %s", err)
+			}
+		}
+
 		strMsg := fmt.Sprintf("Telegram Bot for Alertmanager\nVersion <b>%s</b>\n%s", versionString, helpMsg)
 		msg := tgbotapi.NewMessage(m.Chat.ID, strMsg)
 		msg.ParseMode = tgbotapi.ModeHTML
+		if err := sendMessage(bot, msg); err != nil {
+			return fmt.Errorf("error sending message: %s", err)
+		}
+	case "env":
+		kb := newEnvironmentsKB(bot, m.From)
+
+		msg := tgbotapi.NewMessage(m.Chat.ID, fmt.Sprintf("Active environment: <b>%s</b>\nSelect environment:", env.Name))
+		msg.ParseMode = tgbotapi.ModeHTML
+		msg.ReplyMarkup = kb
+
+		if err := sendMessage(bot, msg); err != nil {
+			return fmt.Errorf("error sending message: %s", err)
+		}
+	case "tenant":
+		if tenant == nil {
+			msg := tgbotapi.NewMessage(m.Chat.ID, "No tenants configured.")
+			if err := sendMessage(bot, msg); err != nil {
+				return fmt.Errorf("error sending message: %s", err)
+			}
+			return nil
+		}
+
+		kb := newTenantsKB(bot, m.From)
+
+		msg := tgbotapi.NewMessage(m.Chat.ID, fmt.Sprintf("Active tenant: <b>%s</b>\nSelect tenant:", tenant.Name))
+		msg.ParseMode = tgbotapi.ModeHTML
+		msg.ReplyMarkup = kb
+
 		if err := sendMessage(bot, msg); err != nil {
 			return fmt.Errorf("error sending message: %s", err)
 		}
@@ -125,7 +257,7 @@ func processMessage(bot *TelegramBot, m *tgbotapi.Message) error {
 			}
 			return nil
 		}
-		if len(argsArr[0]) != 0 && argsArr[0] != "json" {
+		if len(argsArr[0]) != 0 && argsArr[0] != "json" && argsArr[0] != "csv" {
 			msg := tgbotapi.NewMessage(m.Chat.ID, "Unknown argument.")
 			if err := sendMessage(bot, msg); err != nil {
 				return fmt.Errorf("error sending message: %s", err)
@@ -133,18 +265,22 @@ func processMessage(bot *TelegramBot, m *tgbotapi.Message) error {
 			return nil
 		}
 
-		// get active alerts
-		alerts, err := bot.Alertmanager.Alert.GetAlerts(&alert.GetAlertsParams{
-			Context: ctx,
-		})
-		if err != nil {
-			return fmt.Errorf("error getting alerts: %s", err)
-		}
+		// alerts as csv document
+		// e.g. '/alerts csv'
+		if argsArr[0] == "csv" {
+			alerts, err := env.getAlerts(ctx, nil)
+			if err != nil {
+				return fmt.Errorf("error getting alerts: %s", err)
+			}
 
-		if len(alerts.GetPayload()) == 0 {
-			msg := tgbotapi.NewMessage(m.Chat.ID, "No active alerts found.")
-			if err := sendMessage(bot, msg); err != nil {
-				return fmt.Errorf("error sending message: %s", err)
+			data, err := alertsCSV(alerts)
+			if err != nil {
+				return fmt.Errorf("error creating csv: %s", err)
+			}
+
+			summary := fmt.Sprintf("%sActive alerts: %d", environmentHeader(bot, env), len(alerts))
+			if err := sendDocument(bot, m.Chat.ID, data, "alerts.csv", summary); err != nil {
+				return fmt.Errorf("error sending document: %s", err)
 			}
 			return nil
 		}
@@ -152,77 +288,163 @@ func processMessage(bot *TelegramBot, m *tgbotapi.Message) error {
 		// send plain json if no template defined in config
 		// or json send as first command argument
 		// e.g. '/alerts json'
-		if len(cfg.GettableAlertsTemplatePath) == 0 || argsArr[0] == "json" {
-			bytes, err := json.MarshalIndent(alerts.GetPayload(), "", "  ")
-			if err != nil {
-				return fmt.Errorf("error marshalling alerts: %s", err)
-			}
+		asJSON := len(env.GettableAlertsTemplatePath) == 0 || argsArr[0] == "json"
 
-			msg := tgbotapi.NewMessage(m.Chat.ID, string(bytes))
+		// get active alerts
+		s, count, err := formatAlerts(ctx, bot, env, nil, asJSON)
+		if err != nil {
+			return err
+		}
+
+		if count == 0 {
+			msg := tgbotapi.NewMessage(m.Chat.ID, s+"No active alerts found.")
 			if err := sendMessage(bot, msg); err != nil {
 				return fmt.Errorf("error sending message: %s", err)
 			}
 			return nil
 		}
 
-		// send temlated message
-		s, err := applyTemplate(alerts.GetPayload(), cfg.GettableAlertsTemplatePath)
-		if err != nil {
-			return fmt.Errorf("error applying template: %s", err)
+		parseMode, fileName := tgbotapi.ModeHTML, "alerts.html"
+		if asJSON {
+			parseMode, fileName = "", "alerts.json"
 		}
-
-		msg := tgbotapi.NewMessage(m.Chat.ID, s)
-		msg.ParseMode = tgbotapi.ModeHTML
-		if err := sendMessage(bot, msg); err != nil {
+		if err := sendOutput(bot, m.Chat.ID, s, parseMode, fileName, fmt.Sprintf("Active alerts: %d", count)); err != nil {
 			return fmt.Errorf("error sending message: %s", err)
 		}
 	case "targets":
-		kb, err := newJobsKB(bot)
-		if err != nil {
-			return fmt.Errorf("error creating jobs menu: %s", err)
+		// check command arguments
+		argsArr := strings.Fields(m.CommandArguments())
+		if len(argsArr) > 1 {
+			msg := tgbotapi.NewMessage(m.Chat.ID, "Too many arguments.")
+			if err := sendMessage(bot, msg); err != nil {
+				return fmt.Errorf("error sending message: %s", err)
+			}
+			return nil
 		}
 
-		msg := tgbotapi.NewMessage(m.Chat.ID, "Select job:")
-		msg.ReplyMarkup = kb
+		// server could be selected with first argument
+		// e.g. '/targets cluster1'
+		var srv *PrometheusServer
+		if len(argsArr) == 1 {
+			srv = env.getPrometheus(argsArr[0])
+			if srv == nil {
+				msg := tgbotapi.NewMessage(m.Chat.ID, "Unknown prometheus server.")
+				if err := sendMessage(bot, msg); err != nil {
+					return fmt.Errorf("error sending message: %s", err)
+				}
+				return nil
+			}
+		} else if len(env.Prometheus) == 1 {
+			srv = env.Prometheus[0]
+		}
+
+		var msg tgbotapi.MessageConfig
+		if srv == nil {
+			kb, err := newServersKB(ctx, bot, env, 0)
+			if err != nil {
+				return fmt.Errorf("error creating servers menu: %s", err)
+			}
+
+			msg = tgbotapi.NewMessage(m.Chat.ID, "Select server:")
+			msg.ReplyMarkup = kb
+		} else {
+			kb, err := newJobsKB(ctx, bot, env, srv, 0)
+			if err != nil {
+				return fmt.Errorf("error creating jobs menu: %s", err)
+			}
+
+			msg = tgbotapi.NewMessage(m.Chat.ID, "Select job:")
+			msg.ReplyMarkup = kb
+		}
 
 		if err := sendMessage(bot, msg); err != nil {
 			return fmt.Errorf("error sending message: %s", err)
 		}
 	case "status":
-		aStatus, err := bot.Alertmanager.General.GetStatus(&general.GetStatusParams{
-			Context: ctx,
-		})
-		if err != nil {
-			return fmt.Errorf("error getting alertmanager status: %s", err)
+		// status of all prometheus servers
+		// or only of ones set in arguments
+		// e.g. '/status cluster1 cluster2'
+		servers := env.Prometheus
+		if argsArr := strings.Fields(m.CommandArguments()); len(argsArr) > 0 {
+			servers = nil
+			for _, a := range argsArr {
+				srv := env.getPrometheus(a)
+				if srv == nil {
+					msg := tgbotapi.NewMessage(m.Chat.ID, "Unknown prometheus server.")
+					if err := sendMessage(bot, msg); err != nil {
+						return fmt.Errorf("error sending message: %s", err)
+					}
+					return nil
+				}
+				servers = append(servers, srv)
+			}
 		}
 
-		v1api := v1.NewAPI(bot.Prometheus)
-		pBuildInfo, err := v1api.Buildinfo(ctx)
-		if err != nil {
-			return fmt.Errorf("error getting prometheus status: %s", err)
-		}
-		pRTInfo, err := v1api.Runtimeinfo(ctx)
-		if err != nil {
-			return fmt.Errorf("error getting prometheus status: %s", err)
+		var status string
+		if len(cfg().environments) > 1 {
+			status = fmt.Sprintf("\nEnvironment: <b>%s</b>\n", env.Name)
 		}
+		for _, am := range env.Alertmanagers {
+			name := "Alertmanager"
+			if len(env.Alertmanagers) > 1 {
+				name += " " + am.Name
+			}
 
-		status := fmt.Sprintf(`
-Alertmanager
+			aStatus, err := am.GetStatus(ctx)
+			if err != nil {
+				log.Printf("error getting alertmanager '%s' status: %s", am.Name, err)
+				since, _ := am.unreachableSince()
+				status += fmt.Sprintf("\n%s\nUnreachable since: <b>%s</b>\n", name, FormatDate(since))
+				continue
+			}
+
+			status += fmt.Sprintf(`
+%s
 Version: <b>%s</b>
 Uptime: <b>%s</b>
+Cluster: <b>%s</b>
+`,
+				name,
+				*aStatus.VersionInfo.Version,
+				time.Since(time.Time(*aStatus.Uptime)).String(),
+				*aStatus.Cluster.Status)
+		}
 
-Prometheus
+		for _, srv := range servers {
+			name := "Prometheus"
+			if len(env.Prometheus) > 1 {
+				name += " " + srv.Name
+			}
+
+			// server is reported unreachable if any of status requests failed
+			pBuildInfo, err := srv.API.Buildinfo(ctx)
+			var pRTInfo v1.RuntimeinfoResult
+			if err == nil {
+				pRTInfo, err = srv.API.Runtimeinfo(ctx)
+			}
+			if err != nil {
+				log.Printf("error getting prometheus '%s' status: %s", srv.Name, err)
+				srv.update(err)
+				since, _ := srv.unreachableSince()
+				status += fmt.Sprintf("\n%s\nUnreachable since: <b>%s</b>\n", name, FormatDate(since))
+				continue
+			}
+
+			status += fmt.Sprintf(`
+%s
 Version: <b>%s</b>
 Uptime: <b>%s</b>
+`,
+				name,
+				pBuildInfo.Version,
+				time.Since(pRTInfo.StartTime).String())
+		}
 
+		status += fmt.Sprintf(`
 Bot
 Version: <b>%s</b>
 Uptime: <b>%s</b>
-		`,
-			*aStatus.Payload.VersionInfo.Version,
-			time.Since(time.Time(*aStatus.Payload.Uptime)).String(),
-			pBuildInfo.Version,
-			time.Since(pRTInfo.StartTime).String(),
+`,
 			versionString,
 			time.Since(bot.StartTime).String())
 		msg := tgbotapi.NewMessage(m.Chat.ID, status)
@@ -230,6 +452,57 @@ Uptime: <b>%s</b>
 		if err := sendMessage(bot, msg); err != nil {
 			return fmt.Errorf("error sending message: %s", err)
 		}
+	case "query":
+		expr := strings.TrimSpace(m.CommandArguments())
+
+		// server could be selected with first argument
+		// e.g. '/query cluster1 up == 0'
+		srv := env.Prometheus[0]
+		if argsArr := strings.Fields(expr); len(argsArr) > 1 {
+			if s := env.getPrometheus(argsArr[0]); s != nil {
+				srv = s
+				expr = strings.TrimSpace(strings.TrimPrefix(expr, argsArr[0]))
+			}
+		}
+
+		if len(expr) == 0 {
+			msg := tgbotapi.NewMessage(m.Chat.ID, "Query expression is not set.")
+			if err := sendMessage(bot, msg); err != nil {
+				return fmt.Errorf("error sending message: %s", err)
+			}
+			return nil
+		}
+
+		result, warnings, err := srv.API.Query(ctx, expr, time.Now())
+		if err != nil {
+			msg := tgbotapi.NewMessage(m.Chat.ID, "Error running query: "+err.Error())
+			if err := sendMessage(bot, msg); err != nil {
+				return fmt.Errorf("error sending message: %s", err)
+			}
+			return nil
+		}
+		for _, w := range warnings {
+			log.Printf("prometheus '%s' query warning: %s", srv.Name, w)
+		}
+
+		msgText := result.String()
+		if len(msgText) == 0 {
+			msgText = "Empty query result."
+		}
+
+		// large results are sent as plain text document
+		if threshold := cfg().DocumentThreshold; threshold > 0 && utf16Len(msgText) > threshold {
+			if err := sendDocument(bot, m.Chat.ID, []byte(msgText), "query.txt", "Query: "+expr); err != nil {
+				return fmt.Errorf("error sending document: %s", err)
+			}
+			return nil
+		}
+
+		msg := tgbotapi.NewMessage(m.Chat.ID, "<pre>"+html.EscapeString(msgText)+"</pre>")
+		msg.ParseMode = tgbotapi.ModeHTML
+		if err := sendMessage(bot, msg); err != nil {
+			return fmt.Errorf("error sending message: %s", err)
+		}
 	case "silences":
 		// check command arguments
 		args := m.CommandArguments()
@@ -249,54 +522,166 @@ Uptime: <b>%s</b>
 			return nil
 		}
 
-		// get active silences
-		silences, err := bot.Alertmanager.Silence.GetSilences(&silence.GetSilencesParams{
-			Context: ctx,
-		})
-		if err != nil {
-			return fmt.Errorf("error gettnig silences: %s", err)
+		// send plain json if no template defined in config
+		// or json send as first command argument
+		// e.g. '/silences json'
+		asJSON := len(env.SilencesTemplatePath) == 0 || argsArr[0] == "json"
+
+		// get active silences from every alertmanager
+		var msgText, notes string
+		var count int
+		outputs := make(map[string]interface{})
+		for _, am := range env.Alertmanagers {
+			silences, err := am.GetSilences(ctx)
+			if err != nil {
+				if len(env.Alertmanagers) == 1 {
+					return fmt.Errorf("error gettnig silences from alertmanager '%s': %s", am.Name, err)
+				}
+
+				// skip unreachable alertmanager, if there are others
+				log.Printf("error gettnig silences from alertmanager '%s': %s", am.Name, err)
+				since, _ := am.unreachableSince()
+				notes += unreachableMessage("Alertmanager", am.Name, since) + "\n"
+				outputs[am.Name] = map[string]interface{}{"error": err.Error()}
+				continue
+			}
+
+			// TODO: better filter for active silences ??
+			activeSilences := models.GettableSilences{}
+			for _, s := range silences {
+				if *s.Status.State == "active" {
+					activeSilences = append(activeSilences, s)
+				}
+			}
+			outputs[am.Name] = map[string]interface{}{"silences": activeSilences}
+
+			count += len(activeSilences)
+			if len(activeSilences) == 0 || asJSON {
+				continue
+			}
+
+			s, err := applyTemplate(activeSilences, env.SilencesTemplatePath, externalURLFunc(am.URL()), queryFuncs(ctx, env), oncallFuncs(bot))
+			if err != nil {
+				return fmt.Errorf("error applying template: %s", err)
+			}
+			msgText += alertmanagerHeader(env, am) + s + "\n"
 		}
 
-		// TODO: better filter for active silences ??
-		var activeSilences models.GettableSilences
-		for _, s := range silences.GetPayload() {
-			if *s.Status.State == "active" {
-				activeSilences = append(activeSilences, s)
+		switch {
+		case count == 0:
+			msgText = notes
+		case asJSON:
+			var err error
+			if msgText, err = alertmanagersJSON(env, outputs, "silences"); err != nil {
+				return err
 			}
+		default:
+			msgText = notes + msgText
 		}
 
-		if len(activeSilences) == 0 {
-			msg := tgbotapi.NewMessage(m.Chat.ID, "No active silences found.")
+		if count == 0 {
+			msg := tgbotapi.NewMessage(m.Chat.ID, msgText+"No active silences found.")
 			if err := sendMessage(bot, msg); err != nil {
 				return fmt.Errorf("error sending message: %s", err)
 			}
 			return nil
 		}
 
-		// send plain json if no template defined in config
-		// or json send as first command argument
-		// e.g. '/silences json'
-		if len(cfg.GettableAlertsTemplatePath) == 0 || argsArr[0] == "json" {
-			bytes, err := json.MarshalIndent(activeSilences, "", "  ")
-			if err != nil {
-				return fmt.Errorf("error marshalling silences: %s", err)
-			}
-
-			msg := tgbotapi.NewMessage(m.Chat.ID, string(bytes))
+		parseMode, fileName := tgbotapi.ModeHTML, "silences.html"
+		if asJSON {
+			parseMode, fileName = "", "silences.json"
+		}
+		if err := sendOutput(bot, m.Chat.ID, msgText, parseMode, fileName, fmt.Sprintf("Active silences: %d", count)); err != nil {
+			return fmt.Errorf("error sending message: %s", err)
+		}
+	case "oncall":
+		// '/oncall override [rotation] @user 4h' puts user on call from now on
+		argsArr := strings.Fields(m.CommandArguments())
+		if len(argsArr) == 0 {
+			msg := tgbotapi.NewMessage(m.Chat.ID, oncallStatus(bot))
+			msg.ParseMode = tgbotapi.ModeHTML
 			if err := sendMessage(bot, msg); err != nil {
 				return fmt.Errorf("error sending message: %s", err)
 			}
 			return nil
 		}
 
-		// send temlated message
-		s, err := applyTemplate(activeSilences, cfg.SilencesTemplatePath)
+		var msgText string
+		var r *Rotation
+		switch {
+		case !hasRole(role, commandRole("oncall override")):
+			msgText = "You don't have permission to override on-call."
+		case argsArr[0] != "override" || len(argsArr) < 3 || len(argsArr) > 4:
+			msgText = "Usage: /oncall override [rotation] @user <duration>"
+		case len(argsArr) == 4:
+			if r = getRotation(argsArr[1]); r == nil {
+				msgText = "Unknown rotation."
+			}
+			argsArr = argsArr[2:]
+		case len(cfg().rotations) == 0:
+			msgText = "No on-call rotations configured."
+		default:
+			r = cfg().rotations[0]
+			argsArr = argsArr[1:]
+		}
+
+		if r != nil {
+			d, err := model.ParseDuration(argsArr[1])
+			switch {
+			case !r.isOncallUser(argsArr[0]):
+				msgText = fmt.Sprintf("Wrong user '%s', must be telegram username or rotation member.", argsArr[0])
+			case err != nil || d <= 0:
+				msgText = fmt.Sprintf("Wrong duration '%s', must be like 4h or 1d.", argsArr[1])
+			default:
+				now := time.Now()
+				o := OncallOverride{
+					Rotation: r.Name,
+					User:     normalizeUser(argsArr[0]),
+					Start:    now,
+					End:      now.Add(time.Duration(d)),
+					By:       userMention(m.From),
+				}
+				if err := bot.State.AddOncallOverride(o); err != nil {
+					return fmt.Errorf("error saving on-call override: %s", err)
+				}
+				log.Printf("on-call override of rotation '%s' to %s added by %s", r.Name, o.User, o.By)
+				msgText = fmt.Sprintf("@%s is on call in %s until %s.", o.User, r.Name, o.End.In(r.location).Format(cfg().TimeFormat))
+			}
+		}
+
+		msg := tgbotapi.NewMessage(m.Chat.ID, msgText)
+		if err := sendMessage(bot, msg); err != nil {
+			return fmt.Errorf("error sending message: %s", err)
+		}
+	case "subscribe":
+		msgText, err := subscribe(bot, m.From, env, m.CommandArguments())
 		if err != nil {
-			return fmt.Errorf("error applying template: %s", err)
+			return err
+		}
+
+		msg := tgbotapi.NewMessage(m.Chat.ID, msgText)
+		if err := sendMessage(bot, msg); err != nil {
+			return fmt.Errorf("error sending message: %s", err)
 		}
+	case "subscriptions":
+		msgText, kb := newSubscriptionsMessage(bot, m.From.ID)
 
-		msg := tgbotapi.NewMessage(m.Chat.ID, s)
+		msg := tgbotapi.NewMessage(m.Chat.ID, msgText)
 		msg.ParseMode = tgbotapi.ModeHTML
+		if kb != nil {
+			msg.ReplyMarkup = kb
+		}
+		if err := sendMessage(bot, msg); err != nil {
+			return fmt.Errorf("error sending message: %s", err)
+		}
+	case "users":
+		msgText, kb := newUsersMessage(bot)
+
+		msg := tgbotapi.NewMessage(m.Chat.ID, msgText)
+		msg.ParseMode = tgbotapi.ModeHTML
+		if kb != nil {
+			msg.ReplyMarkup = kb
+		}
 		if err := sendMessage(bot, msg); err != nil {
 			return fmt.Errorf("error sending message: %s", err)
 		}
@@ -312,13 +697,69 @@ Uptime: <b>%s</b>
 
 func processCallbackQuery(bot *TelegramBot, cq *tgbotapi.CallbackQuery, cb Callback) error {
 	// api call timeout
-	ctx, cancel := context.WithTimeout(context.Background(), cfg.APITimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg().APITimeout)
 	defer cancel()
 
+	// environment the callback was created in,
+	// chat environment for callbacks without one
+	env := bot.chatEnvironment(cq.Message.Chat.ID)
+	if len(cb.Data["env"]) > 0 {
+		if env = bot.getEnvironment(cb.Data["env"]); env == nil {
+			return fmt.Errorf("unknown environment '%s'", cb.Data["env"])
+		}
+	}
+
+	// same for tenant
+	tenant := bot.chatTenant(cq.Message.Chat.ID)
+	if len(cb.Data["tenant"]) > 0 {
+		if tenant = getTenant(cb.Data["tenant"]); tenant == nil {
+			return fmt.Errorf("unknown tenant '%s'", cb.Data["tenant"])
+		}
+	}
+	ctx = withTenant(ctx, tenant)
+
 	switch cb.Type {
+	case "tenant":
+		if tenant == nil || !tenant.isUserAllowed(cq.From) {
+			return fmt.Errorf("user %s is not allowed in tenant '%s'", cq.From.String(), cb.Data["tenant"])
+		}
+
+		if err := bot.State.SetChatTenant(cq.Message.Chat.ID, tenant.Name); err != nil {
+			return fmt.Errorf("error saving chat tenant: %s", err)
+		}
+
+		msg := tgbotapi.NewEditMessageText(cq.Message.Chat.ID, cq.Message.MessageID, fmt.Sprintf("Active tenant: <b>%s</b>", tenant.Name))
+		msg.ParseMode = tgbotapi.ModeHTML
+
+		if err := sendMessage(bot, msg); err != nil {
+			return fmt.Errorf("error sending message: %s", err)
+		}
+	case "env":
+		if !env.isUserAllowed(cq.From) {
+			return fmt.Errorf("user %s is not allowed in environment '%s'", cq.From.String(), env.Name)
+		}
+
+		if err := bot.State.SetChatEnvironment(cq.Message.Chat.ID, env.Name); err != nil {
+			return fmt.Errorf("error saving chat environment: %s", err)
+		}
+
+		msg := tgbotapi.NewEditMessageText(cq.Message.Chat.ID, cq.Message.MessageID, fmt.Sprintf("Active environment: <b>%s</b>", env.Name))
+		msg.ParseMode = tgbotapi.ModeHTML
+
+		if err := sendMessage(bot, msg); err != nil {
+			return fmt.Errorf("error sending message: %s", err)
+		}
 	case "job":
+		srv := env.getPrometheus(cb.Data["server"])
+		if srv == nil {
+			return fmt.Errorf("unknown prometheus server '%s'", cb.Data["server"])
+		}
+
+		page, _ := strconv.Atoi(cb.Data["page"])
+		jobsPage, _ := strconv.Atoi(cb.Data["jobs_page"])
+
 		// create inline keyboard with targets for requested job
-		kb, err := newTargetsKB(bot, cb.Data["job_name"])
+		kb, err := newTargetsKB(ctx, bot, env, srv, cb.Data["job_name"], cb.Data["unlabelled"] == "yes", page, jobsPage)
 		if err != nil {
 			return fmt.Errorf("error creating targets menu: %s", err)
 		}
@@ -327,7 +768,11 @@ func processCallbackQuery(bot *TelegramBot, cq *tgbotapi.CallbackQuery, cb Callb
 		cacheID := ksuid.New().String()
 		newCallback := Callback{
 			Type: "jobs",
+			Data: make(map[string]string),
 		}
+		newCallback.Data["env"] = env.Name
+		newCallback.Data["server"] = srv.Name
+		newCallback.Data["page"] = cb.Data["jobs_page"]
 		bot.Cache.Set(cacheID, newCallback)
 
 		kb.InlineKeyboard = append(kb.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Go back", cacheID)))
@@ -355,23 +800,35 @@ func processCallbackQuery(bot *TelegramBot, cq *tgbotapi.CallbackQuery, cb Callb
 			return fmt.Errorf("error sending message: %s", err)
 		}
 	case "target":
-		al, err := bot.Alertmanager.Alert.GetAlerts(&alert.GetAlertsParams{
-			Filter:  []string{"instance=" + cb.Data["target_name"]},
-			Context: ctx,
-		})
+		srv := env.getPrometheus(cb.Data["server"])
+		if srv == nil {
+			return fmt.Errorf("unknown prometheus server '%s'", cb.Data["server"])
+		}
+
+		// alerts are matched by instance label
+		// unless another one is set in callback
+		targetLabel := cb.Data["target_label"]
+		if len(targetLabel) == 0 {
+			targetLabel = "instance"
+		}
+		filter := append(srv.AlertFilter(), targetLabel+"="+cb.Data["target_name"])
+		if cb.Data["unlabelled"] == "yes" {
+			filter = append(filter, `job=""`)
+		}
+		// alertname targets are listed for alerts without instance label only
+		if targetLabel == "alertname" {
+			filter = append(filter, `instance=""`)
+		}
+
+		asJSON := len(env.GettableAlertsTemplatePath) == 0
+		s, count, err := formatAlerts(ctx, bot, env, filter, asJSON)
 		if err != nil {
-			return fmt.Errorf("error getting alerts for target '%s': %s", cb.Data["target_name"], err)
+			return err
 		}
 
-		var msgText string
-		if len(al.GetPayload()) > 0 {
-			s, err := applyTemplate(al.GetPayload(), cfg.GettableAlertsTemplatePath)
-			if err != nil {
-				return fmt.Errorf("error applying template: %s", err)
-			}
-			msgText = s
-		} else {
-			msgText = "No active alerts for " + cb.Data["target_name"]
+		msgText := s
+		if count == 0 {
+			msgText = s + "No active alerts for " + cb.Data["target_name"]
 		}
 
 		// create new cache entry
@@ -380,21 +837,50 @@ func processCallbackQuery(bot *TelegramBot, cq *tgbotapi.CallbackQuery, cb Callb
 			Type: "job",
 			Data: make(map[string]string),
 		}
+		newCallback.Data["env"] = env.Name
+		newCallback.Data["server"] = srv.Name
 		newCallback.Data["job_name"] = cb.Data["job_name"]
+		newCallback.Data["unlabelled"] = cb.Data["unlabelled"]
+		newCallback.Data["page"] = cb.Data["page"]
+		newCallback.Data["jobs_page"] = cb.Data["jobs_page"]
 		newCallback.Data["leave_last_message"] = "yes"
 		bot.Cache.Set(cacheID, newCallback)
 
 		kb := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Go back", cacheID)))
 		msg := tgbotapi.NewEditMessageText(cq.Message.Chat.ID, cq.Message.MessageID, msgText)
-		msg.ParseMode = tgbotapi.ModeHTML
+		if !asJSON {
+			msg.ParseMode = tgbotapi.ModeHTML
+		}
+		msg.ReplyMarkup = &kb
+
+		if err := sendMessage(bot, msg); err != nil {
+			return fmt.Errorf("error sending message: %s", err)
+		}
+	case "servers":
+		page, _ := strconv.Atoi(cb.Data["page"])
+
+		// create inline keyboard for all prometheus servers
+		kb, err := newServersKB(ctx, bot, env, page)
+		if err != nil {
+			return fmt.Errorf("error creating servers menu: %s", err)
+		}
+
+		msg := tgbotapi.NewEditMessageText(cq.Message.Chat.ID, cq.Message.MessageID, "Select server:")
 		msg.ReplyMarkup = &kb
 
 		if err := sendMessage(bot, msg); err != nil {
 			return fmt.Errorf("error sending message: %s", err)
 		}
 	case "jobs":
+		srv := env.getPrometheus(cb.Data["server"])
+		if srv == nil {
+			return fmt.Errorf("unknown prometheus server '%s'", cb.Data["server"])
+		}
+
+		page, _ := strconv.Atoi(cb.Data["page"])
+
 		// create inline keyboard for all prometheus jobs
-		kb, err := newJobsKB(bot)
+		kb, err := newJobsKB(ctx, bot, env, srv, page)
 		if err != nil {
 			return fmt.Errorf("error creating jobs menu: %s", err)
 		}
@@ -406,8 +892,16 @@ func processCallbackQuery(bot *TelegramBot, cq *tgbotapi.CallbackQuery, cb Callb
 			return fmt.Errorf("error sending message: %s", err)
 		}
 	case "targets":
+		srv := env.getPrometheus(cb.Data["server"])
+		if srv == nil {
+			return fmt.Errorf("unknown prometheus server '%s'", cb.Data["server"])
+		}
+
+		page, _ := strconv.Atoi(cb.Data["page"])
+		jobsPage, _ := strconv.Atoi(cb.Data["jobs_page"])
+
 		// create inline keyboard with targets for requested job
-		kb, err := newTargetsKB(bot, cb.Data["job_name"])
+		kb, err := newTargetsKB(ctx, bot, env, srv, cb.Data["job_name"], cb.Data["unlabelled"] == "yes", page, jobsPage)
 		if err != nil {
 			return fmt.Errorf("error creating targets menu: %s", err)
 		}
@@ -416,7 +910,11 @@ func processCallbackQuery(bot *TelegramBot, cq *tgbotapi.CallbackQuery, cb Callb
 		cacheID := ksuid.New().String()
 		newCallback := Callback{
 			Type: "jobs",
+			Data: make(map[string]string),
 		}
+		newCallback.Data["env"] = env.Name
+		newCallback.Data["server"] = srv.Name
+		newCallback.Data["page"] = cb.Data["jobs_page"]
 		bot.Cache.Set(cacheID, newCallback)
 
 		kb.InlineKeyboard = append(kb.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Go back", cacheID)))
@@ -432,16 +930,49 @@ func processCallbackQuery(bot *TelegramBot, cq *tgbotapi.CallbackQuery, cb Callb
 		if err := sendMessage(bot, msg); err != nil {
 			return fmt.Errorf("error sending message: %s", err)
 		}
+	case "approve", "deny":
+		if err := processAccessCallback(bot, cq, cb); err != nil {
+			return err
+		}
+	case "users", "user", "set_role", "revoke":
+		if err := processUsersCallback(bot, cq, cb); err != nil {
+			return err
+		}
+	case "unsubscribe":
+		if err := processUnsubscribeCallback(bot, cq, cb); err != nil {
+			return err
+		}
+	case "ack", "unack":
+		if err := processAckCallback(bot, cq, cb); err != nil {
+			return err
+		}
 	case "silence":
 		// HTTPClient := http.Client{}
 
-		ctx, cancel := context.WithTimeout(context.Background(), cfg.APITimeout)
-		defer cancel()
+		// silence is created in alertmanager which sent the alert
+		am := env.getAlertmanager(cb.Data["alertmanager"])
+		if am == nil {
+			return fmt.Errorf("unknown alertmanager '%s'", cb.Data["alertmanager"])
+		}
+
+		g := bot.State.GetAlertGroup(cb.Data["group_id"])
+		if g == nil {
+			return fmt.Errorf("alert group %s not found", cb.Data["group_key"])
+		}
+
+		// group could be silenced with button of another message
+		if g.Silenced {
+			kb := groupKeyboard(g, bot.State.GetAck(g.Key) != nil)
+			if err := sendMessage(bot, tgbotapi.NewEditMessageReplyMarkup(cq.Message.Chat.ID, cq.Message.MessageID, kb)); err != nil {
+				return fmt.Errorf("error sending message: %s", err)
+			}
+			return nil
+		}
 
 		instance_name := "instance"
-		instance_value := cb.Data["instance"]
+		instance_value := g.GroupLabels["instance"]
 		alertname_name := "alertname"
-		alertname_value := cb.Data["alertname"]
+		alertname_value := g.GroupLabels["alertname"]
 		isRegex := false
 
 		matchers := models.Matchers{
@@ -460,40 +991,52 @@ func processCallbackQuery(bot *TelegramBot, cq *tgbotapi.CallbackQuery, cb Callb
 		comment := ""
 		createdBy := programName + " version " + versionString
 		startsAt := strfmt.DateTime(time.Now())
-		endsAt := strfmt.DateTime(time.Now().Add(cfg.SilenceDuration))
-
-		params := silence.PostSilencesParams{
-			Silence: &models.PostableSilence{
-				Silence: models.Silence{
-					Comment:   &comment,
-					CreatedBy: &createdBy,
-					Matchers:  matchers,
-					StartsAt:  &startsAt,
-					EndsAt:    &endsAt,
-				},
+		endsAt := strfmt.DateTime(time.Now().Add(cfg().SilenceDuration))
+
+		postableSilence := models.PostableSilence{
+			Silence: models.Silence{
+				Comment:   &comment,
+				CreatedBy: &createdBy,
+				Matchers:  matchers,
+				StartsAt:  &startsAt,
+				EndsAt:    &endsAt,
 			},
-			Context: ctx,
 		}
 
 		// create new silence
-		ok, err := bot.Alertmanager.Silence.PostSilences(&params)
+		silenceID, err := am.PostSilence(ctx, &postableSilence)
 		if err != nil {
+			msgText := "Error creating silence."
+			if since, ok := am.unreachableSince(); ok {
+				msgText = unreachableMessage("Alertmanager", am.Name, since) + "\nSilence is not created."
+			}
+			msg := tgbotapi.NewMessage(cq.Message.Chat.ID, msgText)
+			if err := sendMessage(bot, msg); err != nil {
+				return fmt.Errorf("error sending message: %s", err)
+			}
 			return fmt.Errorf("error posting new silence: %s", err)
 		}
 
+		// silenced group is not escalated anymore
+		if err := bot.State.RemoveEscalation(g.Key); err != nil {
+			log.Printf("error removing escalation: %s", err)
+		}
+
 		// remove 'Silence' button
-		newMarkup := tgbotapi.InlineKeyboardMarkup{
-			InlineKeyboard: make([][]tgbotapi.InlineKeyboardButton, 0),
+		g.Silenced = true
+		if err := bot.State.SetAlertGroup(cb.Data["group_id"], *g); err != nil {
+			log.Printf("error saving alert group: %s", err)
 		}
+		newMarkup := groupKeyboard(g, bot.State.GetAck(g.Key) != nil)
 		if err := sendMessage(bot, tgbotapi.NewEditMessageReplyMarkup(cq.Message.Chat.ID, cq.Message.MessageID, newMarkup)); err != nil {
 			return fmt.Errorf("error sending message: %s", err)
 		}
 
 		m := fmt.Sprintf(`Created new silence:
-ID: <b>%s</b>
+%sID: <b>%s</b>
 StartsAt: <b>%s</b>
 EndsAt: <b>%s</b>
-Matchers: "[{instance="%s"},{alertname="%s"}]"`, ok.Payload.SilenceID, startsAt, endsAt, instance_value, alertname_value)
+Matchers: "[{instance="%s"},{alertname="%s"}]"`, alertmanagerHeader(env, am), silenceID, startsAt, endsAt, instance_value, alertname_value)
 
 		msg := tgbotapi.NewMessage(cq.Message.Chat.ID, m)
 		msg.ParseMode = tgbotapi.ModeHTML